@@ -0,0 +1,142 @@
+// Package api holds the requests, responses and problems that teams-server and its clients
+// exchange. It only depends on the standard library, so clients do not link the server.
+package api
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+type LoginRequest struct {
+	Username  string    `json:"username"`
+	Timestamp time.Time `json:"timestamp"`
+	Challenge string    `json:"challenge"`
+}
+
+type LoginResponse struct {
+	AccessToken string `json:"access_token"`
+}
+
+type TeamResponse struct {
+	TeamID  string   `json:"team_id"`
+	Members []string `json:"member"`
+}
+
+type TeamRequest struct {
+	Members []string `json:"member"`
+}
+
+type StatusResponse struct {
+	Healthy     bool       `json:"healthy"`
+	Revision    string     `json:"revision,omitempty"`
+	Users       int        `json:"users"`
+	Teams       int        `json:"teams"`
+	LastAttempt *time.Time `json:"last_attempt,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+type ChangesResponse struct {
+	Changes []DataChange `json:"changes"`
+}
+
+type AuditResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+type VerifyResponse struct {
+	Username string `json:"username"`
+	// Teams are the current teams of the user, which may differ from those in the token.
+	Teams []string `json:"teams"`
+}
+
+// DataChange describes the difference between two snapshots. Teams that were added or removed
+// also list all of their members in MembersAdded or MembersRemoved.
+type DataChange struct {
+	Time             time.Time           `json:"time"`
+	PreviousRevision string              `json:"previous_revision,omitempty"`
+	Revision         string              `json:"revision,omitempty"`
+	UsersAdded       []string            `json:"users_added,omitempty"`
+	UsersRemoved     []string            `json:"users_removed,omitempty"`
+	KeysChanged      []string            `json:"keys_changed,omitempty"`
+	RolesChanged     []string            `json:"roles_changed,omitempty"`
+	TeamsAdded       []string            `json:"teams_added,omitempty"`
+	TeamsRemoved     []string            `json:"teams_removed,omitempty"`
+	MembersAdded     map[string][]string `json:"members_added,omitempty"`
+	MembersRemoved   map[string][]string `json:"members_removed,omitempty"`
+}
+
+func (c DataChange) Empty() bool {
+	return len(c.UsersAdded) == 0 && len(c.UsersRemoved) == 0 && len(c.KeysChanged) == 0 &&
+		len(c.RolesChanged) == 0 && len(c.TeamsAdded) == 0 && len(c.TeamsRemoved) == 0 &&
+		len(c.MembersAdded) == 0 && len(c.MembersRemoved) == 0
+}
+
+// AuditEvent records one action. User is the user the action is about, which is the user logging
+// in or the owner of a verified token, and the authenticated caller for data writes.
+type AuditEvent struct {
+	Time      time.Time   `json:"time"`
+	Action    string      `json:"action"`
+	Outcome   string      `json:"outcome"`
+	Reason    string      `json:"reason,omitempty"`
+	User      string      `json:"user,omitempty"`
+	Team      string      `json:"team,omitempty"`
+	Member    string      `json:"member,omitempty"`
+	RemoteIP  string      `json:"remote_ip,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Change    *DataChange `json:"change,omitempty"`
+}
+
+type AuditFilter struct {
+	User  string
+	Since time.Time
+	Until time.Time
+	// Limit keeps only the most recent matching events, if it is positive.
+	Limit int
+}
+
+func (f AuditFilter) Matches(event AuditEvent) bool {
+	if f.User != "" && event.User != f.User && event.Member != f.User {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && event.Time.After(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Identity headers are sent to upstreams by the proxy and answered by GET /forward-auth, so
+// nginx and traefik can pass them on.
+const (
+	HeaderAuthUser  = "X-Auth-User"
+	HeaderAuthTeams = "X-Auth-Teams"
+)
+
+// AccessToken returns the bearer token of the request, or else the value of the cookie.
+func AccessToken(request *http.Request, cookie string) string {
+	if accessToken, found := strings.CutPrefix(request.Header.Get("Authorization"), "Bearer "); found {
+		return accessToken
+	}
+	if cookie == "" {
+		return ""
+	}
+	if value, err := request.Cookie(cookie); err == nil {
+		return value.Value
+	}
+	return ""
+}
+
+// MemberOfAny reports whether one of teams is allowed, which any team is if allowed is empty.
+func MemberOfAny(teams []string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(teams, func(team string) bool {
+		return slices.Contains(allowed, team)
+	})
+}
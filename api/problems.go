@@ -0,0 +1,125 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+)
+
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem codes are stable, so clients can rely on them instead of on status codes or titles.
+const (
+	ProblemInvalidRequest            = "invalid_request"
+	ProblemUnknownUser               = "unknown_user"
+	ProblemInvalidSignature          = "invalid_signature"
+	ProblemChallengeExpired          = "challenge_expired"
+	ProblemRateLimited               = "rate_limited"
+	ProblemLockedOut                 = "locked_out"
+	ProblemMissingToken              = "missing_token"
+	ProblemInvalidToken              = "invalid_token"
+	ProblemTokenExpired              = "token_expired"
+	ProblemForbidden                 = "forbidden"
+	ProblemClientCertificateRequired = "client_certificate_required"
+	ProblemTeamNotFound              = "team_not_found"
+	ProblemEmptyTeam                 = "empty_team"
+	ProblemNotSupported              = "not_supported"
+	ProblemNotFound                  = "not_found"
+	ProblemMethodNotAllowed          = "method_not_allowed"
+	ProblemInternalError             = "internal_error"
+)
+
+var (
+	ErrUnknownUser               = errors.New("unknown user")
+	ErrUnknownTeam               = errors.New("unknown team")
+	ErrEmptyTeam                 = errors.New("team must have at least one member")
+	ErrInvalidRequest            = errors.New("invalid request")
+	ErrInvalidSignature          = errors.New("invalid signature")
+	ErrChallengeExpired          = errors.New("challenge expired")
+	ErrRateLimited               = errors.New("too many attempts")
+	ErrLockedOut                 = errors.New("user locked out")
+	ErrMissingToken              = errors.New("missing access token")
+	ErrInvalidToken              = errors.New("invalid access token")
+	ErrTokenExpired              = errors.New("access token expired")
+	ErrForbidden                 = errors.New("forbidden")
+	ErrClientCertificateRequired = errors.New("client certificate required")
+	ErrNotSupported              = errors.New("not supported by the server")
+	ErrInternal                  = errors.New("internal server error")
+)
+
+var problemErrors = map[string]error{
+	ProblemInvalidRequest:            ErrInvalidRequest,
+	ProblemUnknownUser:               ErrUnknownUser,
+	ProblemInvalidSignature:          ErrInvalidSignature,
+	ProblemChallengeExpired:          ErrChallengeExpired,
+	ProblemRateLimited:               ErrRateLimited,
+	ProblemLockedOut:                 ErrLockedOut,
+	ProblemMissingToken:              ErrMissingToken,
+	ProblemInvalidToken:              ErrInvalidToken,
+	ProblemTokenExpired:              ErrTokenExpired,
+	ProblemForbidden:                 ErrForbidden,
+	ProblemClientCertificateRequired: ErrClientCertificateRequired,
+	ProblemTeamNotFound:              ErrUnknownTeam,
+	ProblemEmptyTeam:                 ErrEmptyTeam,
+	ProblemNotSupported:              ErrNotSupported,
+	ProblemInternalError:             ErrInternal,
+}
+
+var problemTitles = map[string]string{
+	ProblemInvalidRequest:            "The request is invalid",
+	ProblemUnknownUser:               "The user does not exist",
+	ProblemInvalidSignature:          "The signature is invalid",
+	ProblemChallengeExpired:          "The challenge timestamp is too far from the server time",
+	ProblemRateLimited:               "Too many attempts",
+	ProblemLockedOut:                 "The user is locked after repeated failures",
+	ProblemMissingToken:              "The access token is missing",
+	ProblemInvalidToken:              "The access token is invalid",
+	ProblemTokenExpired:              "The access token expired",
+	ProblemForbidden:                 "The roles of the caller do not allow the request",
+	ProblemClientCertificateRequired: "A client certificate is required",
+	ProblemTeamNotFound:              "The team does not exist",
+	ProblemEmptyTeam:                 "A team must have at least one member",
+	ProblemNotSupported:              "The server does not support the request",
+	ProblemNotFound:                  "The resource does not exist",
+	ProblemMethodNotAllowed:          "The method is not allowed",
+	ProblemInternalError:             "Internal server error",
+}
+
+// Problem is an RFC 7807 problem detail with the stable code of the problem as extension.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ProblemError is returned by the client for problem responses. It unwraps to the error of its
+// code, so callers can check for example errors.Is(err, ErrTokenExpired).
+type ProblemError struct {
+	Problem
+}
+
+func (e *ProblemError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%s: %s", e.Title, e.Detail)
+	}
+	return e.Title
+}
+
+func (e *ProblemError) Unwrap() error {
+	return problemErrors[e.Code]
+}
+
+// NewProblem returns the problem of a code, for services that answer with the same problems as
+// the server.
+func NewProblem(status int, code string, detail string) Problem {
+	return Problem{
+		Type:   "urn:teams:problem:" + code,
+		Title:  problemTitles[code],
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
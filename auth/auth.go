@@ -0,0 +1,111 @@
+// Package auth authenticates requests to services with the access tokens of teams-server. The
+// middleware puts the identity of the caller into the request context, where handlers and
+// RequireTeam find it.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/pscheid/teams/api"
+	"github.com/pscheid/teams/client"
+	"net/http"
+	"slices"
+)
+
+type Identity struct {
+	Username string
+	Teams    []string
+}
+
+func (i Identity) MemberOf(team string) bool {
+	return slices.Contains(i.Teams, team)
+}
+
+// Verifier returns the identity of the owner of an access token. Rejected tokens are reported
+// with an error matching client.ErrInvalidToken, client.ErrTokenExpired or client.ErrUnknownUser.
+type Verifier interface {
+	Verify(ctx context.Context, accessToken string) (Identity, error)
+}
+
+type identityContextKey struct{}
+
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityContextKey{}).(Identity)
+	return identity, ok
+}
+
+// Middleware answers requests without a valid bearer token with a problem, and passes the others
+// on with the identity of the caller in their context.
+func Middleware(verifier Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := authenticate(w, r, verifier, "")
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+		})
+	}
+}
+
+// RequireTeam only passes on requests of members of the team. It must come after Middleware.
+func RequireTeam(team string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, found := FromContext(r.Context())
+			if !found {
+				writeProblem(w, r, http.StatusUnauthorized, api.ProblemMissingToken)
+				return
+			}
+			if !identity.MemberOf(team) {
+				writeProblem(w, r, http.StatusForbidden, api.ProblemForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// authenticate answers the request with a problem if it has no valid access token.
+func authenticate(w http.ResponseWriter, r *http.Request, verifier Verifier, cookie string) (Identity, bool) {
+	accessToken := api.AccessToken(r, cookie)
+	if accessToken == "" {
+		writeProblem(w, r, http.StatusUnauthorized, api.ProblemMissingToken)
+		return Identity{}, false
+	}
+
+	identity, err := verifier.Verify(r.Context(), accessToken)
+	if err != nil {
+		status, code := rejection(err)
+		writeProblem(w, r, status, code)
+		return Identity{}, false
+	}
+	return identity, true
+}
+
+func rejection(err error) (int, string) {
+	switch {
+	case errors.Is(err, client.ErrTokenExpired):
+		return http.StatusUnauthorized, api.ProblemTokenExpired
+	case errors.Is(err, client.ErrInvalidToken), errors.Is(err, client.ErrUnknownUser):
+		return http.StatusUnauthorized, api.ProblemInvalidToken
+	default:
+		// the server could not be asked, the token may well be valid
+		return http.StatusServiceUnavailable, api.ProblemInternalError
+	}
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, code string) {
+	problem := api.NewProblem(status, code, "")
+	problem.Instance = r.URL.Path
+	problem.RequestID = w.Header().Get("X-Request-Id")
+
+	w.Header().Set("Content-Type", api.MIMEApplicationProblemJSON)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
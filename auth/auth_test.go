@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pscheid/teams/api"
+	"github.com/pscheid/teams/client"
+	"github.com/pscheid/teams/internal"
+	"github.com/pscheid/teams/internal/testserver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareRequiresTeam(t *testing.T) {
+	server, jwt := testserver.New(t, internal.DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": {1}, "bob": {1}},
+		Teams: map[string][]string{"team-1": {"alice"}, "team-2": {"alice", "bob"}},
+	})
+	config := JwtConfig{Issuer: testserver.Config.Issuer, Audience: testserver.Config.Audience, Secret: testserver.Config.Secret}
+
+	var verifications atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/verify" {
+			verifications.Add(1)
+		}
+		server.ServeHTTP(w, r)
+	}))
+	defer httpServer.Close()
+
+	teamsClient, err := client.New(client.Config{BaseURL: httpServer.URL})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	alice, _ := jwt.Create("alice", nil, []string{"team-1", "team-2"}, time.Now())
+	bob, _ := jwt.Create("bob", nil, []string{"team-2"}, time.Now())
+	expired, _ := jwt.Create("alice", nil, []string{"team-1", "team-2"}, time.Now().Add(-2*time.Hour))
+	forged, _ := internal.NewJwtHelper(internal.JwtHelperConfig{Issuer: "test", Audience: "test", Secret: []byte("other")}).Create("bob", nil, []string{"team-1"}, time.Now())
+
+	verifiers := map[string]Verifier{
+		"local":  newLocalVerifier(t, config),
+		"remote": NewRemoteVerifier(teamsClient, time.Minute),
+	}
+	for name, verifier := range verifiers {
+		handler := Middleware(verifier)(RequireTeam("team-1")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, _ := FromContext(r.Context())
+			_, _ = w.Write([]byte(identity.Username + " " + strings.Join(identity.Teams, ",")))
+		})))
+
+		requests := []struct {
+			token  string
+			status int
+			body   string
+		}{
+			{alice, http.StatusOK, "alice team-1,team-2"},
+			{alice, http.StatusOK, "alice team-1,team-2"},
+			{bob, http.StatusForbidden, api.ProblemForbidden},
+			{"", http.StatusUnauthorized, api.ProblemMissingToken},
+			{expired, http.StatusUnauthorized, api.ProblemTokenExpired},
+			{forged, http.StatusUnauthorized, api.ProblemInvalidToken},
+		}
+		for i, r := range requests {
+			request := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+			if r.token != "" {
+				request.Header.Set("Authorization", "Bearer "+r.token)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			body := recorder.Body.String()
+			if r.status != http.StatusOK {
+				problem := client.Problem{}
+				_ = json.Unmarshal(recorder.Body.Bytes(), &problem)
+				body = problem.Code
+			}
+			if recorder.Code != r.status || body != r.body {
+				t.Errorf("%s %d: expected %d %s, got %d %s", name, i, r.status, r.body, recorder.Code, body)
+			}
+		}
+	}
+
+	if verifications.Load() != 4 {
+		t.Errorf("expected the remote verifier to ask once per token, got %d verifications", verifications.Load())
+	}
+}
+
+func newLocalVerifier(t *testing.T, config JwtConfig) *LocalVerifier {
+	t.Helper()
+
+	verifier, err := NewLocalVerifier(config)
+	if err != nil {
+		t.Fatalf("create verifier: %v", err)
+	}
+	return verifier
+}
+
+func TestLocalVerifierRequiresKey(t *testing.T) {
+	if _, err := NewLocalVerifier(JwtConfig{Issuer: "test", Audience: "test"}); !errors.Is(err, ErrMissingKey) {
+		t.Errorf("expected a config without key to be rejected, got %v", err)
+	}
+	forged, _ := internal.NewJwtHelper(internal.JwtHelperConfig{Issuer: "test", Audience: "test"}).Create("mallory", nil, []string{"admins"}, time.Now())
+	if _, err := (&LocalVerifier{}).Verify(context.Background(), forged); !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("expected a token signed with an empty secret to be invalid, got %v", err)
+	}
+}
+
+func TestLocalVerifierChecksPublicKey(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	other, _, _ := ed25519.GenerateKey(nil)
+
+	signed, _ := internal.NewJwtHelper(internal.JwtHelperConfig{Issuer: "test", Audience: "test", PrivateKey: private}).Create("alice", nil, []string{"team-1"}, time.Now())
+	shared, _ := internal.NewJwtHelper(internal.JwtHelperConfig{Issuer: "test", Audience: "test", Secret: public}).Create("alice", nil, []string{"team-1"}, time.Now())
+
+	verifier := newLocalVerifier(t, JwtConfig{Issuer: "test", Audience: "test", PublicKey: public})
+	if identity, err := verifier.Verify(context.Background(), signed); err != nil || identity.Username != "alice" || !identity.MemberOf("team-1") {
+		t.Errorf("expected the token signed with the private key to be valid, got %+v %v", identity, err)
+	}
+	// the public key must not be usable as a secret to mint tokens
+	if _, err := verifier.Verify(context.Background(), shared); !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("expected a token signed with the public key as secret to be invalid, got %v", err)
+	}
+	if _, err := newLocalVerifier(t, JwtConfig{Issuer: "test", Audience: "test", PublicKey: other}).Verify(context.Background(), signed); !errors.Is(err, client.ErrInvalidToken) {
+		t.Errorf("expected the token to be invalid for another key, got %v", err)
+	}
+}
+
+func TestRemoteVerifierReportsUnreachableServer(t *testing.T) {
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer httpServer.Close()
+
+	teamsClient, err := client.New(client.Config{BaseURL: httpServer.URL, MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	e := echo.New()
+	e.GET("/dashboard", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, EchoMiddleware(NewRemoteVerifier(teamsClient, time.Minute)), EchoRequireTeam("team-1"))
+
+	request := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while the server is unreachable, got %d", recorder.Code)
+	}
+}
+
+func TestRemoteVerifierOnlyCachesRejections(t *testing.T) {
+	var verifications atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, code := http.StatusUnauthorized, api.ProblemInvalidToken
+		if verifications.Add(1); r.URL.Query().Get("access_token") == "valid" {
+			status, code = http.StatusServiceUnavailable, api.ProblemInternalError
+			if verifications.Load() > 1 {
+				_ = json.NewEncoder(w).Encode(api.VerifyResponse{Username: "alice", Teams: []string{"team-1"}})
+				return
+			}
+		}
+		w.Header().Set("Content-Type", api.MIMEApplicationProblemJSON)
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(api.NewProblem(status, code, ""))
+	}))
+	defer httpServer.Close()
+
+	teamsClient, err := client.New(client.Config{BaseURL: httpServer.URL, MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	verifier := NewRemoteVerifier(teamsClient, time.Minute)
+
+	if _, err := verifier.Verify(context.Background(), "valid"); !errors.Is(err, client.ErrInternal) {
+		t.Fatalf("expected the failure of the server, got %v", err)
+	}
+	if identity, err := verifier.Verify(context.Background(), "valid"); err != nil || identity.Username != "alice" {
+		t.Errorf("expected the failure not to be cached, got %+v %v", identity, err)
+	}
+
+	forged, _ := internal.NewJwtHelper(internal.JwtHelperConfig{Issuer: "test", Audience: "test", Secret: []byte("forged")}).Create("mallory", nil, nil, time.Now())
+	_, _ = verifier.Verify(context.Background(), forged)
+	before := verifications.Load()
+	if _, err := verifier.Verify(context.Background(), forged); !errors.Is(err, client.ErrInvalidToken) || verifications.Load() != before {
+		t.Errorf("expected the rejection to be cached, got %v", err)
+	}
+
+	// tokens that are no jwt at all would only fill the cache
+	_, _ = verifier.Verify(context.Background(), "junk")
+	before = verifications.Load()
+	if _, err := verifier.Verify(context.Background(), "junk"); !errors.Is(err, client.ErrInvalidToken) || verifications.Load() == before {
+		t.Errorf("expected the rejection of junk not to be cached, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+package auth
+
+import "github.com/labstack/echo/v4"
+
+// EchoMiddleware is Middleware for echo. Handlers find the identity with
+// FromContext(c.Request().Context()).
+func EchoMiddleware(verifier Verifier) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(Middleware(verifier))
+}
+
+func EchoRequireTeam(team string) echo.MiddlewareFunc {
+	return echo.WrapMiddleware(RequireTeam(team))
+}
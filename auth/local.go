@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pscheid/teams/client"
+	"time"
+)
+
+// JwtConfig must match the jwt settings of the server. PublicKey verifies tokens the server signs
+// with its jwt private key. Secret verifies tokens signed with the shared jwt secret, and whoever
+// holds it can mint valid tokens for any user, admins included, so only set it in services that
+// are as trusted as the server.
+type JwtConfig struct {
+	Issuer    string
+	Audience  string
+	Leeway    time.Duration
+	PublicKey ed25519.PublicKey
+	Secret    []byte
+}
+
+// LocalVerifier validates access tokens without asking the server. The teams are those of the
+// user at login, and tokens of removed users stay valid until they expire.
+type LocalVerifier struct {
+	config  JwtConfig
+	options []jwt.ParserOption
+}
+
+type claims struct {
+	jwt.RegisteredClaims
+	Teams []string `json:"teams,omitempty"`
+}
+
+var ErrMissingKey = errors.New("jwt config has neither a public key nor a secret")
+
+// NewLocalVerifier fails without key material, as an empty secret would verify tokens anyone can
+// sign.
+func NewLocalVerifier(config JwtConfig) (*LocalVerifier, error) {
+	method := jwt.SigningMethodHS512.Name
+	switch {
+	case len(config.PublicKey) == ed25519.PublicKeySize:
+		method = jwt.SigningMethodEdDSA.Alg()
+	case config.PublicKey != nil:
+		return nil, fmt.Errorf("jwt public key has %d bytes, expected %d", len(config.PublicKey), ed25519.PublicKeySize)
+	case len(config.Secret) == 0:
+		return nil, ErrMissingKey
+	}
+
+	return &LocalVerifier{
+		config: config,
+		options: []jwt.ParserOption{
+			jwt.WithIssuedAt(),
+			jwt.WithExpirationRequired(),
+			jwt.WithAudience(config.Audience),
+			jwt.WithIssuer(config.Issuer),
+			jwt.WithLeeway(config.Leeway),
+			jwt.WithValidMethods([]string{method}),
+		},
+	}, nil
+}
+
+func (v *LocalVerifier) Verify(_ context.Context, accessToken string) (Identity, error) {
+	verified := claims{}
+	_, err := jwt.ParseWithClaims(accessToken, &verified, v.resolveKey, v.options...)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return Identity{}, fmt.Errorf("%w: %w", client.ErrTokenExpired, err)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %w", client.ErrInvalidToken, err)
+	}
+	return Identity{Username: verified.Subject, Teams: verified.Teams}, nil
+}
+
+func (v *LocalVerifier) resolveKey(_ *jwt.Token) (interface{}, error) {
+	if v.config.PublicKey != nil {
+		return v.config.PublicKey, nil
+	}
+	if len(v.config.Secret) == 0 {
+		// a zero LocalVerifier must not accept tokens signed with an empty secret
+		return nil, ErrMissingKey
+	}
+	return v.config.Secret, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// The proxy sends the identity of the caller to the upstream in these headers.
+const (
+	HeaderUser  = api.HeaderAuthUser
+	HeaderTeams = api.HeaderAuthTeams
+)
+
+type ProxyConfig struct {
+	Verifier Verifier
+	// Cookie is read when there is no bearer token, and removed before forwarding.
+	Cookie string
+	// Teams whose members reach the upstream, all users if it is empty.
+	Teams []string
+	// Transport forwards the requests, http.DefaultTransport if it is nil.
+	Transport http.RoundTripper
+}
+
+// NewProxy forwards requests of members of the allowed teams to upstream. The identity headers
+// replace any the caller sent, and the access token is removed, so the upstream cannot reuse it.
+func NewProxy(upstream *url.URL, config ProxyConfig) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			identity, _ := FromContext(r.In.Context())
+			r.SetURL(upstream)
+			r.SetXForwarded()
+			r.Out.Header.Set(HeaderUser, identity.Username)
+			r.Out.Header.Set(HeaderTeams, strings.Join(identity.Teams, ","))
+			removeAccessToken(r.Out, config.Cookie)
+		},
+		Transport: config.Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("proxying request failed", slog.String("path", r.URL.Path), slog.Any("error", err))
+			writeProblem(w, r, http.StatusBadGateway, api.ProblemInternalError)
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, ok := authenticate(w, r, config.Verifier, config.Cookie)
+		if !ok {
+			return
+		}
+		if !api.MemberOfAny(identity.Teams, config.Teams) {
+			writeProblem(w, r, http.StatusForbidden, api.ProblemForbidden)
+			return
+		}
+		proxy.ServeHTTP(w, r.WithContext(NewContext(r.Context(), identity)))
+	})
+}
+
+func removeAccessToken(request *http.Request, cookie string) {
+	if strings.HasPrefix(request.Header.Get("Authorization"), "Bearer ") {
+		request.Header.Del("Authorization")
+	}
+	if cookie == "" {
+		return
+	}
+
+	cookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != cookie {
+			request.AddCookie(c)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"github.com/pscheid/teams/internal"
+	"github.com/pscheid/teams/internal/testserver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyForwardsMembersWithIdentity(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookies := make([]string, 0)
+		for _, c := range r.Cookies() {
+			cookies = append(cookies, c.Name)
+		}
+		_, _ = w.Write([]byte(strings.Join([]string{
+			r.URL.Path,
+			r.Header.Get(HeaderUser),
+			r.Header.Get(HeaderTeams),
+			r.Header.Get("Authorization"),
+			strings.Join(cookies, ","),
+		}, " ")))
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	config := JwtConfig{Issuer: testserver.Config.Issuer, Audience: testserver.Config.Audience, Secret: testserver.Config.Secret}
+	jwt := internal.NewJwtHelper(testserver.Config)
+	alice, _ := jwt.Create("alice", nil, []string{"team-1", "team-2"}, time.Now())
+	bob, _ := jwt.Create("bob", nil, []string{"team-2"}, time.Now())
+
+	proxy := NewProxy(upstreamURL, ProxyConfig{Verifier: newLocalVerifier(t, config), Cookie: "teams_token", Teams: []string{"team-1"}})
+
+	requests := []struct {
+		header string
+		cookie string
+		status int
+		body   string
+	}{
+		{"Bearer " + alice, "", http.StatusOK, "/dashboard alice team-1,team-2  theme"},
+		{"", alice, http.StatusOK, "/dashboard alice team-1,team-2  theme"},
+		{"", bob, http.StatusForbidden, ""},
+		{"", "", http.StatusUnauthorized, ""},
+	}
+	for i, r := range requests {
+		request := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		request.Header.Set(HeaderUser, "admin")
+		request.AddCookie(&http.Cookie{Name: "theme", Value: "dark"})
+		if r.header != "" {
+			request.Header.Set("Authorization", r.header)
+		}
+		if r.cookie != "" {
+			request.AddCookie(&http.Cookie{Name: "teams_token", Value: r.cookie})
+		}
+		recorder := httptest.NewRecorder()
+		proxy.ServeHTTP(recorder, request)
+
+		if recorder.Code != r.status || (r.status == http.StatusOK && recorder.Body.String() != r.body) {
+			t.Errorf("%d: expected %d %q, got %d %q", i, r.status, r.body, recorder.Code, recorder.Body.String())
+		}
+	}
+}
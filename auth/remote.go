@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pscheid/teams/client"
+	"sync"
+	"time"
+)
+
+// RemoteVerifier asks the server through /verify, so removed users and changed teams take effect
+// after at most CacheTTL.
+type RemoteVerifier struct {
+	client    *client.Client
+	ttl       time.Duration
+	mutex     sync.Mutex
+	cache     map[string]verification
+	nextSweep time.Time
+}
+
+// maxCachedRejections bounds the cache, as anyone can send tokens that are rejected.
+const maxCachedRejections = 10000
+
+type verification struct {
+	identity Identity
+	err      error
+	expires  time.Time
+}
+
+// NewRemoteVerifier caches the answers of the server for ttl, 1 minute if it is not positive.
+func NewRemoteVerifier(client *client.Client, ttl time.Duration) *RemoteVerifier {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &RemoteVerifier{client: client, ttl: ttl, cache: make(map[string]verification)}
+}
+
+func (v *RemoteVerifier) Verify(ctx context.Context, accessToken string) (Identity, error) {
+	now := time.Now()
+
+	v.mutex.Lock()
+	cached, found := v.cache[accessToken]
+	v.mutex.Unlock()
+	if found && now.Before(cached.expires) {
+		return cached.identity, cached.err
+	}
+
+	response, err := v.client.Verify(ctx, accessToken)
+	if err != nil && !rejected(err) {
+		// failures of the server are not remembered, the token may well be valid
+		return Identity{}, err
+	}
+
+	identity := Identity{Username: response.Username, Teams: response.Teams}
+	expires, parsed := v.expiry(accessToken, now)
+	if err != nil && !parsed {
+		// junk is not remembered, it would fill the cache
+		return identity, err
+	}
+	v.store(accessToken, verification{identity: identity, err: err, expires: expires}, now)
+	return identity, err
+}
+
+// rejected reports whether the server rejected the token itself, so asking again is pointless.
+func rejected(err error) bool {
+	return errors.Is(err, client.ErrInvalidToken) || errors.Is(err, client.ErrTokenExpired) || errors.Is(err, client.ErrUnknownUser)
+}
+
+// expiry is the end of the cache period, but not after the token expires. The server verified
+// the token, its expiry is only read. It reports whether the token could be parsed at all.
+func (v *RemoteVerifier) expiry(accessToken string, now time.Time) (time.Time, bool) {
+	expires := now.Add(v.ttl)
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, &claims); err != nil {
+		return expires, false
+	}
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expires) {
+		expires = claims.ExpiresAt.Time
+	}
+	return expires, true
+}
+
+func (v *RemoteVerifier) store(accessToken string, result verification, now time.Time) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if now.After(v.nextSweep) {
+		for token, cached := range v.cache {
+			if now.After(cached.expires) {
+				delete(v.cache, token)
+			}
+		}
+		v.nextSweep = now.Add(v.ttl)
+	}
+	if result.err != nil && len(v.cache) >= maxCachedRejections {
+		return
+	}
+	v.cache[accessToken] = result
+}
@@ -0,0 +1,361 @@
+// Package client calls the API of teams-server. The request and response types are those of the
+// server, so they always match what it sends.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	LoginRequest   = api.LoginRequest
+	LoginResponse  = api.LoginResponse
+	VerifyResponse = api.VerifyResponse
+	TeamResponse   = api.TeamResponse
+	StatusResponse = api.StatusResponse
+	DataChange     = api.DataChange
+	AuditEvent     = api.AuditEvent
+	AuditFilter    = api.AuditFilter
+	Problem        = api.Problem
+	ProblemError   = api.ProblemError
+)
+
+type Config struct {
+	// BaseURL is the URL the server is reachable at, like https://teams.example.org/.
+	BaseURL string
+	// HTTPClient sends the requests. If it is nil, a client with Transport is used.
+	HTTPClient *http.Client
+	// Transport defaults to http.DefaultTransport. It is wrapped to send the W3C trace context of
+	// the request context, so the server continues the trace of the caller.
+	Transport http.RoundTripper
+	// Timeout limits every attempt of a request, 10s by default.
+	Timeout time.Duration
+	// MaxAttempts is how often requests that can be repeated safely are sent, until they do not
+	// fail with a network error or a 502, 503 or 504 response. It is 3 by default.
+	MaxAttempts   int
+	RetryInterval time.Duration
+	MaxBackoff    time.Duration
+	// TokenSource provides the access token of requests that need one.
+	TokenSource TokenSource
+}
+
+type Client struct {
+	config  Config
+	baseURL *url.URL
+}
+
+func New(config Config) (*Client, error) {
+	baseURL, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("client: %w", err)
+	}
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("client: base url %q is not absolute", config.BaseURL)
+	}
+	if !strings.HasSuffix(baseURL.Path, "/") {
+		baseURL.Path += "/"
+	}
+
+	if config.HTTPClient == nil {
+		transport := config.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		config.HTTPClient = &http.Client{Transport: otelhttp.NewTransport(transport)}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 200 * time.Millisecond
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Second
+	}
+
+	return &Client{config: config, baseURL: baseURL}, nil
+}
+
+// WithTokenSource returns a copy of the client that authenticates with the given source.
+func (c *Client) WithTokenSource(source TokenSource) *Client {
+	config := c.config
+	config.TokenSource = source
+	return &Client{config: config, baseURL: c.baseURL}
+}
+
+func (c *Client) Login(ctx context.Context, request LoginRequest) (LoginResponse, error) {
+	result := LoginResponse{}
+	err := c.do(ctx, call{method: http.MethodPost, path: []string{"login"}, body: request, result: &result})
+	if err != nil {
+		return result, fmt.Errorf("login: %w", err)
+	}
+	return result, nil
+}
+
+// LoginWithKey signs a challenge with the key of the user and returns the access token.
+func (c *Client) LoginWithKey(ctx context.Context, username string, key ed25519.PrivateKey) (string, error) {
+	now := time.Now()
+	response, err := c.Login(ctx, LoginRequest{
+		Username:  username,
+		Timestamp: now,
+		Challenge: api.CreateChallenge(username, now, key),
+	})
+	return response.AccessToken, err
+}
+
+func (c *Client) Verify(ctx context.Context, accessToken string) (VerifyResponse, error) {
+	result := VerifyResponse{}
+	query := url.Values{"access_token": {accessToken}}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"verify"}, query: query, retry: true, result: &result})
+	if err != nil {
+		return result, fmt.Errorf("verify: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) Team(ctx context.Context, team string) (TeamResponse, error) {
+	result := TeamResponse{}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"teams", team}, retry: true, result: &result})
+	if err != nil {
+		return result, fmt.Errorf("team: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) PutTeam(ctx context.Context, team string, members []string) error {
+	request := api.TeamRequest{Members: members}
+	err := c.do(ctx, call{method: http.MethodPut, path: []string{"teams", team}, body: request, authenticated: true, retry: true})
+	if err != nil {
+		return fmt.Errorf("put team: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) DeleteTeam(ctx context.Context, team string) error {
+	err := c.do(ctx, call{method: http.MethodDelete, path: []string{"teams", team}, authenticated: true, retry: true})
+	if err != nil {
+		return fmt.Errorf("delete team: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) AddTeamMember(ctx context.Context, team string, username string) error {
+	err := c.do(ctx, call{method: http.MethodPut, path: []string{"teams", team, "members", username}, authenticated: true, retry: true})
+	if err != nil {
+		return fmt.Errorf("add team member: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) RemoveTeamMember(ctx context.Context, team string, username string) error {
+	err := c.do(ctx, call{method: http.MethodDelete, path: []string{"teams", team, "members", username}, authenticated: true, retry: true})
+	if err != nil {
+		return fmt.Errorf("remove team member: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Status(ctx context.Context) (StatusResponse, error) {
+	result := StatusResponse{}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"status"}, retry: true, result: &result})
+	if err != nil {
+		return result, fmt.Errorf("status: %w", err)
+	}
+	return result, nil
+}
+
+func (c *Client) Changes(ctx context.Context) ([]DataChange, error) {
+	result := api.ChangesResponse{}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"changes"}, retry: true, result: &result})
+	if err != nil {
+		return nil, fmt.Errorf("changes: %w", err)
+	}
+	return result.Changes, nil
+}
+
+// Audit queries the audit log, which requires the admin role.
+func (c *Client) Audit(ctx context.Context, filter AuditFilter) ([]AuditEvent, error) {
+	query := url.Values{}
+	if filter.User != "" {
+		query.Set("user", filter.User)
+	}
+	if !filter.Since.IsZero() {
+		query.Set("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		query.Set("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Limit > 0 {
+		query.Set("limit", strconv.Itoa(filter.Limit))
+	}
+
+	result := api.AuditResponse{}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"audit"}, query: query, authenticated: true, retry: true, result: &result})
+	if err != nil {
+		return nil, fmt.Errorf("audit: %w", err)
+	}
+	return result.Events, nil
+}
+
+// Health asks the server once, without retrying, whether it is healthy. Live and Ready probe the
+// liveness and readiness the same way.
+func (c *Client) Health(ctx context.Context) error {
+	return c.probe(ctx, "health")
+}
+
+func (c *Client) Live(ctx context.Context) error {
+	return c.probe(ctx, "livez")
+}
+
+func (c *Client) Ready(ctx context.Context) error {
+	return c.probe(ctx, "readyz")
+}
+
+func (c *Client) probe(ctx context.Context, path string) error {
+	if err := c.do(ctx, call{method: http.MethodGet, path: []string{path}}); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// OpenAPI returns the OpenAPI document of the server.
+func (c *Client) OpenAPI(ctx context.Context) (json.RawMessage, error) {
+	result := json.RawMessage{}
+	err := c.do(ctx, call{method: http.MethodGet, path: []string{"openapi.json"}, retry: true, result: &result})
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return result, nil
+}
+
+type call struct {
+	method string
+	// path segments are escaped, so a team named a/b stays one segment
+	path          []string
+	query         url.Values
+	body          any
+	authenticated bool
+	retry         bool
+	result        any
+}
+
+func (c *Client) do(ctx context.Context, call call) error {
+	var body []byte
+	if call.body != nil {
+		encoded, err := json.Marshal(call.body)
+		if err != nil {
+			return err
+		}
+		body = encoded
+	}
+
+	for attempt := 1; ; attempt++ {
+		retryable, err := c.attempt(ctx, call, body)
+		if err == nil || !retryable || !call.retry || attempt >= c.config.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff(c.config.RetryInterval, c.config.MaxBackoff, attempt-1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the request once and reports whether a failure may be resolved by retrying.
+func (c *Client) attempt(ctx context.Context, call call, body []byte) (bool, error) {
+	attemptCtx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	escaped := make([]string, len(call.path))
+	for i, segment := range call.path {
+		escaped[i] = url.PathEscape(segment)
+	}
+	target := *c.baseURL
+	target.Path = c.baseURL.Path + strings.Join(call.path, "/")
+	target.RawPath = c.baseURL.EscapedPath() + strings.Join(escaped, "/")
+	target.RawQuery = call.query.Encode()
+
+	request, err := http.NewRequestWithContext(attemptCtx, call.method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Accept", "application/json, "+api.MIMEApplicationProblemJSON)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if call.authenticated && c.config.TokenSource != nil {
+		token, err := c.config.TokenSource.Token(ctx)
+		if err != nil {
+			return false, fmt.Errorf("access token: %w", err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := c.config.HTTPClient.Do(request)
+	if err != nil {
+		// a timeout of the attempt is retried, a cancelled context of the caller is not
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusOK && response.StatusCode < http.StatusMultipleChoices {
+		if call.result == nil {
+			return false, nil
+		}
+		if err := json.NewDecoder(response.Body).Decode(call.result); err != nil {
+			return false, fmt.Errorf("decode response: %w", err)
+		}
+		return false, nil
+	}
+
+	retryable := response.StatusCode == http.StatusBadGateway ||
+		response.StatusCode == http.StatusServiceUnavailable ||
+		response.StatusCode == http.StatusGatewayTimeout
+
+	if mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type")); mediaType == api.MIMEApplicationProblemJSON {
+		problem := Problem{}
+		if err := json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(&problem); err == nil && problem.Code != "" {
+			return retryable, &ProblemError{Problem: problem}
+		}
+	}
+	return retryable, &StatusError{StatusCode: response.StatusCode}
+}
+
+// StatusError is returned for unsuccessful responses that are not problems, for example from a
+// proxy in front of the server.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unsuccessful status code %d", e.StatusCode)
+}
+
+func backoff(interval time.Duration, limit time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/pscheid/teams/api"
+	"github.com/pscheid/teams/internal"
+	"github.com/pscheid/teams/internal/testserver"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientAgainstServer(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	server, jwt := testserver.New(t, internal.DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": pub, "bob": pub},
+		Roles: map[string][]internal.Role{"alice": {{Name: internal.RoleAdmin}}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	})
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	client, err := New(Config{BaseURL: httpServer.URL})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+	admin := client.WithTokenSource(NewKeyTokenSource(client, "alice", priv))
+	ctx := context.Background()
+
+	if err := admin.PutTeam(ctx, "team-2", []string{"bob"}); err != nil {
+		t.Fatalf("put team: %v", err)
+	}
+	if err := admin.AddTeamMember(ctx, "team-2", "alice"); err != nil {
+		t.Fatalf("add team member: %v", err)
+	}
+	team, err := client.Team(ctx, "team-2")
+	if err != nil || len(team.Members) != 2 {
+		t.Fatalf("expected both members, got %+v, %v", team, err)
+	}
+	if err := admin.RemoveTeamMember(ctx, "team-2", "alice"); err != nil {
+		t.Fatalf("remove team member: %v", err)
+	}
+	if err := admin.DeleteTeam(ctx, "team-2"); err != nil {
+		t.Fatalf("delete team: %v", err)
+	}
+
+	token, err := client.LoginWithKey(ctx, "bob", priv)
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if response, err := client.Verify(ctx, token); err != nil || response.Username != "bob" {
+		t.Errorf("expected token of bob, got %+v, %v", response, err)
+	}
+
+	expired, _ := jwt.Create("bob", nil, nil, time.Now().Add(-2*time.Hour))
+	stale := time.Now().Add(-time.Hour)
+	_, staleErr := client.Login(ctx, LoginRequest{Username: "bob", Timestamp: stale, Challenge: api.CreateChallenge("bob", stale, priv)})
+	_, expiredErr := client.Verify(ctx, expired)
+	_, unknownErr := client.Team(ctx, "unknown")
+
+	failures := []struct {
+		err      error
+		expected error
+	}{
+		{staleErr, ErrChallengeExpired},
+		{expiredErr, ErrTokenExpired},
+		{unknownErr, ErrUnknownTeam},
+		{client.DeleteTeam(ctx, "team-1"), ErrMissingToken},
+		{client.WithTokenSource(StaticToken(token)).DeleteTeam(ctx, "team-1"), ErrForbidden},
+		{admin.RemoveTeamMember(ctx, "team-1", "alice"), ErrEmptyTeam},
+		{admin.Health(ctx), nil},
+	}
+	for i, f := range failures {
+		if !errors.Is(f.err, f.expected) {
+			t.Errorf("%d: expected %v, got %v", i, f.expected, f.err)
+		}
+	}
+}
+
+func TestClientRetriesIdempotentRequests(t *testing.T) {
+	var requests atomic.Int32
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1)%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.EscapedPath() != "/api/teams/a%2Fb" {
+			t.Errorf("expected team to be one path segment, got %s", r.URL.EscapedPath())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"team_id":"a/b","member":["alice"]}`))
+	}))
+	defer httpServer.Close()
+
+	client, err := New(Config{BaseURL: httpServer.URL + "/api", RetryInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("create client: %v", err)
+	}
+
+	team, err := client.Team(context.Background(), "a/b")
+	if err != nil || team.TeamID != "a/b" || requests.Load() != 3 {
+		t.Errorf("expected lookup to succeed on the third attempt, got %+v, %v after %d requests", team, err, requests.Load())
+	}
+
+	requests.Store(0)
+	_, err = client.Login(context.Background(), LoginRequest{Username: "alice"})
+	status := &StatusError{}
+	if !errors.As(err, &status) || status.StatusCode != http.StatusServiceUnavailable || requests.Load() != 1 {
+		t.Errorf("expected login not to be retried, got %v after %d requests", err, requests.Load())
+	}
+
+	requests.Store(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Status(ctx); !errors.Is(err, context.Canceled) || requests.Load() != 0 {
+		t.Errorf("expected cancelled context to stop the request, got %v after %d requests", err, requests.Load())
+	}
+}
@@ -0,0 +1,22 @@
+package client
+
+import "github.com/pscheid/teams/api"
+
+// Problems of the server are returned as *ProblemError, which matches these errors with errors.Is.
+var (
+	ErrInvalidRequest            = api.ErrInvalidRequest
+	ErrUnknownUser               = api.ErrUnknownUser
+	ErrInvalidSignature          = api.ErrInvalidSignature
+	ErrChallengeExpired          = api.ErrChallengeExpired
+	ErrRateLimited               = api.ErrRateLimited
+	ErrLockedOut                 = api.ErrLockedOut
+	ErrMissingToken              = api.ErrMissingToken
+	ErrInvalidToken              = api.ErrInvalidToken
+	ErrTokenExpired              = api.ErrTokenExpired
+	ErrForbidden                 = api.ErrForbidden
+	ErrClientCertificateRequired = api.ErrClientCertificateRequired
+	ErrUnknownTeam               = api.ErrUnknownTeam
+	ErrEmptyTeam                 = api.ErrEmptyTeam
+	ErrNotSupported              = api.ErrNotSupported
+	ErrInternal                  = api.ErrInternal
+)
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"github.com/golang-jwt/jwt/v5"
+	"sync"
+	"time"
+)
+
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// StaticToken is an access token that was obtained elsewhere, for example with `teams login`.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+// KeyTokenSource logs in with the key of a user and reuses the access token until shortly before
+// it expires.
+type KeyTokenSource struct {
+	client   *Client
+	username string
+	key      ed25519.PrivateKey
+	mutex    sync.Mutex
+	token    string
+	expires  time.Time
+}
+
+func NewKeyTokenSource(client *Client, username string, key ed25519.PrivateKey) *KeyTokenSource {
+	return &KeyTokenSource{client: client, username: username, key: key}
+}
+
+func (s *KeyTokenSource) Token(ctx context.Context) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.token != "" && time.Now().Before(s.expires.Add(-time.Minute)) {
+		return s.token, nil
+	}
+
+	token, err := s.client.LoginWithKey(ctx, s.username, s.key)
+	if err != nil {
+		return "", err
+	}
+
+	// the server verifies the token, the expiry is only read to know when to renew it
+	claims := jwt.RegisteredClaims{}
+	s.token, s.expires = token, time.Time{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, &claims); err == nil && claims.ExpiresAt != nil {
+		s.expires = claims.ExpiresAt.Time
+	}
+	return token, nil
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/pscheid/teams/internal"
 	"github.com/spf13/cobra"
 	"log"
-	"time"
 )
 
 func buildLoginCmd() *cobra.Command {
@@ -36,21 +35,12 @@ func buildLoginCmd() *cobra.Command {
 				log.Fatalln(err)
 			}
 
-			now := time.Now()
-			challenge := internal.CreateChallenge(username, now, key)
-
-			requestBody := internal.LoginRequest{
-				Username:  username,
-				Timestamp: now,
-				Challenge: challenge,
-			}
-
-			response, err := client.Login(requestBody)
+			accessToken, err := client.LoginWithKey(cmd.Context(), username, key)
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln(explain(err))
 			}
 
-			fmt.Println(response.AccessToken)
+			fmt.Println(accessToken)
 		},
 	}
 }
@@ -69,9 +59,9 @@ func buildVerifyCmd() *cobra.Command {
 				log.Fatalln(err)
 			}
 
-			response, err := client.Verify(accessToken)
+			response, err := client.Verify(cmd.Context(), accessToken)
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln(explain(err))
 			}
 
 			fmt.Printf("verified for %s\n", response.Username)
@@ -93,9 +83,9 @@ func buildListTeamCmd() *cobra.Command {
 				log.Fatalln(err)
 			}
 
-			team, err := client.Team(teamID)
+			team, err := client.Team(cmd.Context(), teamID)
 			if err != nil {
-				log.Fatalln(err)
+				log.Fatalln(explain(err))
 			}
 
 			for _, username := range team.Members {
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/internal"
+	"github.com/spf13/cobra"
+	"log"
+	"os"
+)
+
+func buildDataCmd() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "data",
+		Short: "Work with server data files",
+	}
+	command.AddCommand(
+		buildSignDataCmd(),
+		buildValidateDataCmd(),
+	)
+	return command
+}
+
+func buildSignDataCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "sign",
+		Short: "Create a detached signature for a data file with the key of a user.",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := args[0]
+			username := args[1]
+			app := cmd.Context().Value("app").(*AppContext)
+
+			keysSet, err := app.BuildKeysSet()
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			key, err := keysSet.GetPrivateKey(username)
+			if errors.Is(err, internal.ErrKeysNotFound) {
+				log.Fatalln("unknown username")
+			}
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			signaturePath := path + internal.SignatureSuffix
+			if err := os.WriteFile(signaturePath, internal.SignData(content, key), 0o644); err != nil {
+				log.Fatalln(err)
+			}
+
+			fmt.Printf("signature written to %s\n", signaturePath)
+		},
+	}
+}
+
+func buildValidateDataCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Check data files, directories or globs for problems.",
+		Args:  cobra.MinimumNArgs(1),
+		// validation is useful without a client configuration, e.g. in pre-commit hooks
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {},
+		Run: func(cmd *cobra.Command, args []string) {
+			snapshot, err := internal.ValidateDataFiles(args)
+
+			var validationErr *internal.ValidationError
+			if errors.As(err, &validationErr) {
+				for _, problem := range validationErr.Problems {
+					fmt.Fprintln(os.Stderr, problem)
+				}
+				os.Exit(1)
+			}
+			if err != nil {
+				log.Fatalln(err)
+			}
+
+			fmt.Printf("valid: %d users and %d teams\n", len(snapshot.Users), len(snapshot.Teams))
+		},
+	}
+}
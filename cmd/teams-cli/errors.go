@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/client"
+)
+
+var explanations = []struct {
+	err         error
+	explanation string
+}{
+	{client.ErrUnknownUser, "the server does not know this user"},
+	{client.ErrInvalidSignature, "the server rejected the signature, check that the key in the config belongs to the public key registered for the user"},
+	{client.ErrChallengeExpired, "the server rejected the login time, check that the clock of this machine is correct"},
+	{client.ErrRateLimited, "too many attempts, wait a moment before trying again"},
+	{client.ErrLockedOut, "the user is locked after repeated failed logins, wait a few minutes before trying again"},
+	{client.ErrMissingToken, "the request needs an access token, run `teams login` first"},
+	{client.ErrTokenExpired, "the access token expired, run `teams login` for a new one"},
+	{client.ErrInvalidToken, "the access token was not issued by this server or was modified"},
+	{client.ErrForbidden, "the roles of the user do not allow this"},
+	{client.ErrClientCertificateRequired, "the server requires a client certificate, set tls::cert_file and tls::key_file in the config"},
+	{client.ErrUnknownTeam, "the team does not exist"},
+	{client.ErrEmptyTeam, "a team must keep at least one member"},
+	{client.ErrNotSupported, "the server is not configured for this"},
+	{client.ErrInvalidRequest, "the server could not understand the request, check that the cli and the server versions match"},
+	{client.ErrInternal, "the server failed, its log has the details"},
+}
+
+// explain prefixes errors of the server with an explanation of what to do about them.
+func explain(err error) string {
+	for _, e := range explanations {
+		if errors.Is(err, e.err) {
+			return fmt.Sprintf("%s (%v)", e.explanation, err)
+		}
+	}
+	return err.Error()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/pscheid/teams/client"
 	"github.com/pscheid/teams/internal"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -34,6 +35,7 @@ func main() {
 		buildLoginCmd(),
 		buildVerifyCmd(),
 		buildListTeamCmd(),
+		buildDataCmd(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -75,12 +77,32 @@ func NewAppContext(cmd *cobra.Command, configFile string) (*AppContext, error) {
 	return app, nil
 }
 
-func (app *AppContext) BuildClient() (*internal.Client, error) {
+func (app *AppContext) BuildClient() (*client.Client, error) {
 	server := app.config.GetString("server")
 	if server == "" {
 		return nil, errors.New("building client: no server specified")
 	}
-	return internal.NewClient(server), nil
+
+	config := client.Config{BaseURL: server, Timeout: app.config.GetDuration("timeout")}
+
+	tlsConfig := client.TLSConfig{
+		CAFile:   app.config.GetString("tls::ca_file"),
+		CertFile: app.config.GetString("tls::cert_file"),
+		KeyFile:  app.config.GetString("tls::key_file"),
+	}
+	if tlsConfig != (client.TLSConfig{}) {
+		transport, err := tlsConfig.Transport()
+		if err != nil {
+			return nil, fmt.Errorf("building client: %w", err)
+		}
+		config.Transport = transport
+	}
+
+	c, err := client.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("building client: %w", err)
+	}
+	return c, nil
 }
 
 func (app *AppContext) BuildKeysSet() (*internal.KeysSet, error) {
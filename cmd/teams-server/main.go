@@ -1,27 +1,261 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
 	"github.com/pscheid/teams/internal"
+	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
+const (
+	BackendYAML     = "yaml"
+	BackendSQLite   = "sqlite"
+	BackendPostgres = "postgres"
+	BackendLDAP     = "ldap"
+	BackendGit      = "git"
+	BackendHTTP     = "http"
+)
+
+type importableRepository interface {
+	internal.DataRepository
+	io.Closer
+	Import(snapshot internal.DataSnapshot) error
+}
+
 func main() {
-	config := loadConfig()
+	rootCmd := &cobra.Command{
+		Use:   "teams-server",
+		Short: "Serve team memberships and access tokens",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serve(loadConfig())
+		},
+	}
+
+	rootCmd.AddCommand(buildImportCmd())
+	rootCmd.AddCommand(buildProxyCmd())
+
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func buildImportCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "import",
+		Short: "Replace the contents of the sqlite or postgres database with data files",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			config := loadConfig()
 
-	monitor := buildDataMonitor(config)
-	defer monitor.Close()
+			snapshot, err := internal.LoadDataFiles(args)
+			if err != nil {
+				fatal("loading data files failed", slog.Any("error", err))
+			}
+
+			repository := buildDatabaseRepository(config)
+			defer repository.Close()
+
+			if err := repository.Import(snapshot); err != nil {
+				fatal("importing data failed", slog.Any("error", err))
+			}
+
+			fmt.Printf("imported %d users and %d teams\n", len(snapshot.Users), len(snapshot.Teams))
+		},
+	}
+}
+
+func serve(config *viper.Viper) {
+	repository, backend := buildRepository(config)
+	defer backend.Close()
 
 	jwt := buildJwtHelper(config)
-	repository := internal.NewYAMLFileDataRepository(monitor)
 
-	server := internal.NewServer(jwt, repository)
+	trustedProxies, err := internal.ParseTrustedProxies(config.GetStringSlice("server.trusted_proxies"))
+	if err != nil {
+		fatal("invalid trusted proxies", slog.Any("error", err))
+	}
+
+	serverConfig := internal.ServerConfig{
+		ReportDegradedHealth: config.GetBool("health.report_degraded"),
+		ChallengeMaxAge:      config.GetDuration("login.challenge_max_age"),
+
+		ClientCertificateRoutes: config.GetStringSlice("tls.client_certificate_routes"),
+
+		LoginLimiter: internal.NewLoginLimiter(internal.LoginLimiterConfig{
+			IP:              internal.RateLimit{Burst: config.GetInt("login.rate_limit.ip.burst"), Refill: config.GetDuration("login.rate_limit.ip.refill")},
+			User:            internal.RateLimit{Burst: config.GetInt("login.rate_limit.user.burst"), Refill: config.GetDuration("login.rate_limit.user.refill")},
+			MaxFailures:     config.GetInt("login.lockout.max_failures"),
+			LockoutDuration: config.GetDuration("login.lockout.duration"),
+		}, nil),
+		UniformLoginErrors: config.GetBool("login.uniform_errors"),
+
+		Gateway:        buildGatewayConfig(config),
+		TrustedProxies: trustedProxies,
+	}
+
+	shutdownTracing := buildTracing(config)
+	defer shutdownTracing(context.Background())
+
+	server := internal.NewServer(serverConfig, jwt, repository)
+	server.InitTracing(config.GetString("tracing.service_name"))
 	server.InitRoutes()
 
-	if err := server.Start(":8080"); err != nil {
-		log.Fatalln(err)
+	if refresher, ok := backend.(internal.Refresher); ok {
+		if secret := config.GetString("data.webhook_secret"); secret != "" {
+			server.InitRefreshWebhook(refresher, secret)
+		}
+	}
+
+	if dispatcher := buildWebhookDispatcher(config, backend); dispatcher != nil {
+		defer dispatcher.Close()
+	}
+
+	if audit := buildAuditLog(config); audit != nil {
+		defer audit.Close()
+		server.InitAudit(audit)
+		if source, ok := backend.(internal.ChangeSubscriber); ok {
+			audit.RecordChanges(source)
+		}
 	}
+
+	var metricsServer *http.Server
+	if config.GetBool("metrics.enabled") {
+		metrics := internal.NewMetrics(repository)
+		server.InitMetrics(metrics)
+
+		if address := config.GetString("metrics.listen"); address != "" {
+			metricsServer = serveMetrics(address, metrics)
+		} else {
+			server.GET("metrics", echo.WrapHandler(metrics.Handler()))
+		}
+	}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	start, closeTLS := buildStart(config, server)
+	defer closeTLS()
+
+	failed := make(chan error, 1)
+	go func() {
+		if err := start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		fatal("server failed", slog.Any("error", err))
+	case <-signals.Done():
+	}
+
+	// a second signal terminates immediately
+	stopSignals()
+	shutdown(config, server, metricsServer)
+}
+
+// buildStart returns the function that starts the server with or without TLS, depending on
+// whether a certificate is configured.
+func buildStart(config *viper.Viper, server *internal.Server) (func() error, func() error) {
+	address := config.GetString("server.address")
+
+	tlsConfig := internal.TLSConfig{
+		CertFile:       config.GetString("tls.cert_file"),
+		KeyFile:        config.GetString("tls.key_file"),
+		ClientCAFile:   config.GetString("tls.client_ca_file"),
+		ReloadInterval: config.GetDuration("tls.reload_interval"),
+	}
+	if tlsConfig.CertFile == "" {
+		if tlsConfig.ClientCAFile != "" || len(config.GetStringSlice("tls.client_certificate_routes")) > 0 {
+			fatal("client certificates require a tls certificate")
+		}
+		return func() error {
+			slog.Info("listening", slog.String("address", address))
+			return server.Start(address)
+		}, func() error { return nil }
+	}
+	if tlsConfig.ClientCAFile == "" && len(config.GetStringSlice("tls.client_certificate_routes")) > 0 {
+		fatal("client certificate routes require a client ca file")
+	}
+
+	reloader, err := internal.NewCertificateReloader(tlsConfig)
+	if err != nil {
+		fatal("loading tls certificate failed", slog.Any("error", err))
+	}
+	serverTLSConfig, err := reloader.ServerConfig()
+	if err != nil {
+		fatal("loading client ca failed", slog.Any("error", err))
+	}
+
+	return func() error {
+		slog.Info("listening with tls", slog.String("address", address), slog.Bool("client_certificates", tlsConfig.ClientCAFile != ""))
+		return server.StartTLSConfig(address, serverTLSConfig)
+	}, reloader.Close
+}
+
+// shutdown reports the server as not ready, waits for load balancers to notice and then drains
+// in-flight requests. The deferred calls in serve stop the data backend and flush the sinks.
+func shutdown(config *viper.Viper, server *internal.Server, metricsServer *http.Server) {
+	slog.Info("shutting down")
+	server.BeginShutdown()
+	time.Sleep(config.GetDuration("shutdown.delay"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetDuration("shutdown.timeout"))
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("draining requests failed", slog.Any("error", err))
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("stopping metrics server failed", slog.Any("error", err))
+		}
+	}
+}
+
+func buildTracing(config *viper.Viper) func(context.Context) error {
+	tracingConfig := internal.TracingConfig{
+		ServiceName: config.GetString("tracing.service_name"),
+		Exporter:    config.GetString("tracing.exporter"),
+		Endpoint:    config.GetString("tracing.endpoint"),
+		Insecure:    config.GetBool("tracing.insecure"),
+		File:        config.GetString("tracing.file"),
+		SampleRatio: config.GetFloat64("tracing.sample_ratio"),
+	}
+
+	shutdown, err := internal.InitTracing(tracingConfig)
+	if err != nil {
+		fatal("setting up tracing failed", slog.Any("error", err))
+	}
+	return shutdown
+}
+
+func serveMetrics(address string, metrics *internal.Metrics) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+
+	metricsServer := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("metrics server failed", slog.Any("error", err))
+		}
+	}()
+	return metricsServer
 }
 
 func loadConfig() *viper.Viper {
@@ -33,43 +267,307 @@ func loadConfig() *viper.Viper {
 	_ = config.BindEnv("jwt.secret", "JWT_SECRET")
 	_ = config.BindEnv("data.path", "DATA_PATH")
 
+	config.SetDefault("data.backend", BackendYAML)
+	config.SetDefault("tracing.service_name", "teams-server")
+	config.SetDefault("tracing.sample_ratio", 1.0)
+	config.SetDefault("log.level", "info")
+	config.SetDefault("shutdown.timeout", 30*time.Second)
+	config.SetDefault("server.address", ":8080")
+	config.SetDefault("proxy.address", ":8081")
+	config.SetDefault("log.format", "text")
+
 	config.SetEnvPrefix("TEAMS")
 	config.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	config.AutomaticEnv()
 
 	if err := config.ReadInConfig(); err != nil {
-		log.Fatalln("error reading configuration file")
+		fatal("reading configuration file failed", slog.Any("error", err))
 	}
 
+	slog.SetDefault(buildLogger(config))
 	return config
 }
 
-func buildJwtHelper(config *viper.Viper) *internal.JwtHelper {
-	sub := config.Sub("jwt")
+func buildLogger(config *viper.Viper) *slog.Logger {
+	level := slog.LevelInfo
+	if err := level.UnmarshalText([]byte(config.GetString("log.level"))); err != nil {
+		fatal("invalid log level", slog.String("level", config.GetString("log.level")))
+	}
 
-	secret := sub.GetString("secret")
-	if secret == "" {
-		log.Fatalln("missing jwt secret")
+	options := &slog.HandlerOptions{Level: level}
+	switch format := config.GetString("log.format"); format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options))
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
+	default:
+		fatal("unknown log format", slog.String("format", format))
+		return nil
 	}
+}
+
+func fatal(message string, args ...any) {
+	slog.Error(message, args...)
+	os.Exit(1)
+}
+
+func buildJwtHelper(config *viper.Viper) *internal.JwtHelper {
+	return internal.NewJwtHelper(buildJwtConfig(config))
+}
+
+func buildJwtConfig(config *viper.Viper) internal.JwtHelperConfig {
+	sub := config.Sub("jwt")
 
 	jwtConfig := internal.JwtHelperConfig{
 		Issuer:   sub.GetString("issuer"),
 		Audience: sub.GetString("audience"),
 		Leeway:   sub.GetDuration("leeway"),
-		Secret:   []byte(secret),
+		Secret:   []byte(sub.GetString("secret")),
+	}
+	if privateKey := sub.GetString("private_key"); privateKey != "" {
+		blob, err := base64.StdEncoding.DecodeString(privateKey)
+		if err != nil || len(blob) != ed25519.PrivateKeySize {
+			fatal("invalid jwt private key")
+		}
+		jwtConfig.PrivateKey = blob
+	} else if len(jwtConfig.Secret) == 0 {
+		fatal("missing jwt secret")
+	}
+	return jwtConfig
+}
+
+func buildGatewayConfig(config *viper.Viper) internal.GatewayConfig {
+	return internal.GatewayConfig{
+		Cookie:    config.GetString("gateway.cookie"),
+		Teams:     config.GetStringSlice("gateway.teams"),
+		TeamQuery: config.GetBool("gateway.team_query"),
+	}
+}
+
+func buildWebhookDispatcher(config *viper.Viper, backend io.Closer) *internal.WebhookDispatcher {
+	targets := []internal.WebhookTarget{}
+	if err := config.UnmarshalKey("webhooks.targets", &targets); err != nil {
+		fatal("invalid webhook targets", slog.Any("error", err))
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+
+	source, ok := backend.(internal.ChangeSubscriber)
+	if !ok {
+		fatal("data backend does not support webhooks", slog.String("backend", config.GetString("data.backend")))
+	}
+
+	webhookConfig := internal.WebhookConfig{
+		Targets:       targets,
+		Outbox:        config.GetString("webhooks.outbox"),
+		Timeout:       config.GetDuration("webhooks.timeout"),
+		RetryInterval: config.GetDuration("webhooks.retry_interval"),
+		MaxBackoff:    config.GetDuration("webhooks.max_backoff"),
+		MaxAttempts:   config.GetInt("webhooks.max_attempts"),
+	}
+
+	dispatcher, err := internal.NewWebhookDispatcher(webhookConfig, source)
+	if err != nil {
+		fatal("setting up webhooks failed", slog.Any("error", err))
+	}
+	return dispatcher
+}
+
+func buildAuditLog(config *viper.Viper) *internal.AuditLog {
+	var sinks []internal.AuditSink
+
+	if config.GetBool("audit.stdout") {
+		sinks = append(sinks, internal.NewWriterAuditSink(os.Stdout))
+	}
+
+	if path := config.GetString("audit.file.path"); path != "" {
+		sink, err := internal.NewFileAuditSink(internal.AuditFileConfig{
+			Path:     path,
+			MaxSize:  int64(config.GetSizeInBytes("audit.file.max_size")),
+			MaxFiles: config.GetInt("audit.file.max_files"),
+		})
+		if err != nil {
+			fatal("opening audit log failed", slog.Any("error", err))
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if len(sinks) == 0 {
+		return nil
+	}
+	return internal.NewAuditLog(sinks...)
+}
+
+func buildRepository(config *viper.Viper) (internal.DataRepository, io.Closer) {
+	switch backend := config.GetString("data.backend"); backend {
+	case BackendYAML:
+		monitor := buildDataMonitor(config)
+		if !monitor.Writable() {
+			return internal.NewSnapshotDataRepository(monitor), monitor
+		}
+		return internal.NewYAMLFileDataRepository(monitor), monitor
+	case BackendSQLite, BackendPostgres:
+		repository := buildDatabaseRepository(config)
+		return repository, repository
+	case BackendLDAP:
+		source := buildLDAPDataSource(config)
+		return internal.NewSnapshotDataRepository(source), source
+	case BackendGit:
+		source := buildGitDataSource(config)
+		return internal.NewSnapshotDataRepository(source), source
+	case BackendHTTP:
+		source := buildHTTPDataSource(config)
+		return internal.NewSnapshotDataRepository(source), source
+	default:
+		fatal("unknown data backend", slog.String("backend", backend))
+		return nil, nil
 	}
-	return internal.NewJwtHelper(jwtConfig)
 }
 
 func buildDataMonitor(config *viper.Viper) *internal.DataMonitor {
-	path := config.GetString("data.path")
-	if path == "" {
-		log.Fatalln("missing data path")
+	paths := getStrings(config, "data.path")
+	if len(paths) == 0 {
+		fatal("missing data path")
+	}
+
+	monitorConfig := internal.DataMonitorConfig{
+		Paths:        paths,
+		Debounce:     config.GetDuration("data.debounce"),
+		PollInterval: config.GetDuration("data.poll_interval"),
+	}
+
+	if config.GetBool("data.signature.required") {
+		keys, err := internal.ParsePublicKeys(config.GetStringSlice("data.signature.trusted_keys"))
+		if err != nil {
+			fatal("invalid trusted keys", slog.Any("error", err))
+		}
+		if len(keys) == 0 {
+			fatal("data signature required, but no trusted keys configured")
+		}
+		monitorConfig.TrustedKeys = keys
 	}
 
-	monitor, err := internal.NewDataMonitor(path)
+	monitor, err := internal.NewDataMonitor(monitorConfig)
 	if err != nil {
-		panic(err)
+		fatal("loading data files failed", slog.Any("error", err))
 	}
 	return monitor
 }
+
+// getStrings reads a setting that is either a single string or a list of strings. Unlike
+// viper's GetStringSlice, a single string is not split at whitespace.
+func getStrings(config *viper.Viper, key string) []string {
+	switch value := config.Get(key).(type) {
+	case nil:
+		return nil
+	case string:
+		if value == "" {
+			return nil
+		}
+		return []string{value}
+	default:
+		return config.GetStringSlice(key)
+	}
+}
+
+func buildDatabaseRepository(config *viper.Viper) importableRepository {
+	switch backend := config.GetString("data.backend"); backend {
+	case BackendSQLite:
+		return buildSQLiteRepository(config)
+	case BackendPostgres:
+		return buildPostgresRepository(config)
+	default:
+		fatal("data backend is not a database", slog.String("backend", backend))
+		return nil
+	}
+}
+
+func buildSQLiteRepository(config *viper.Viper) *internal.SQLiteDataRepository {
+	path := config.GetString("data.sqlite.path")
+	if path == "" {
+		fatal("missing sqlite database path")
+	}
+
+	repository, err := internal.NewSQLiteDataRepository(path)
+	if err != nil {
+		fatal("opening sqlite database failed", slog.Any("error", err))
+	}
+	return repository
+}
+
+func buildPostgresRepository(config *viper.Viper) *internal.PostgresDataRepository {
+	url := config.GetString("data.postgres.url")
+	if url == "" {
+		fatal("missing postgres url")
+	}
+
+	postgresConfig := internal.PostgresConfig{
+		URL:           url,
+		RetryInterval: config.GetDuration("data.postgres.retry_interval"),
+	}
+
+	repository, err := internal.NewPostgresDataRepository(postgresConfig)
+	if err != nil {
+		fatal("opening postgres database failed", slog.Any("error", err))
+	}
+	return repository
+}
+
+func buildLDAPDataSource(config *viper.Viper) *internal.LDAPDataSource {
+	ldapConfig := internal.LDAPConfig{
+		URL:             config.GetString("data.ldap.url"),
+		BindDN:          config.GetString("data.ldap.bind_dn"),
+		BindPassword:    config.GetString("data.ldap.bind_password"),
+		RefreshInterval: config.GetDuration("data.ldap.refresh_interval"),
+		PageSize:        config.GetUint32("data.ldap.page_size"),
+
+		UserBaseDN:        config.GetString("data.ldap.users.base_dn"),
+		UserFilter:        config.GetString("data.ldap.users.filter"),
+		UserNameAttribute: config.GetString("data.ldap.users.name_attribute"),
+		UserKeyAttribute:  config.GetString("data.ldap.users.key_attribute"),
+
+		GroupBaseDN:          config.GetString("data.ldap.groups.base_dn"),
+		GroupFilter:          config.GetString("data.ldap.groups.filter"),
+		GroupNameAttribute:   config.GetString("data.ldap.groups.name_attribute"),
+		GroupMemberAttribute: config.GetString("data.ldap.groups.member_attribute"),
+	}
+
+	source, err := internal.NewLDAPDataSource(ldapConfig)
+	if err != nil {
+		fatal("connecting to ldap failed", slog.Any("error", err))
+	}
+	return source
+}
+
+func buildGitDataSource(config *viper.Viper) *internal.GitDataSource {
+	gitConfig := internal.GitConfig{
+		URL:          config.GetString("data.git.url"),
+		Ref:          config.GetString("data.git.ref"),
+		Path:         config.GetString("data.git.path"),
+		Directory:    config.GetString("data.git.directory"),
+		PollInterval: config.GetDuration("data.git.poll_interval"),
+	}
+
+	source, err := internal.NewGitDataSource(gitConfig)
+	if err != nil {
+		fatal("setting up git data source failed", slog.Any("error", err))
+	}
+	return source
+}
+
+func buildHTTPDataSource(config *viper.Viper) *internal.HTTPDataSource {
+	httpConfig := internal.HTTPConfig{
+		URL:        config.GetString("data.http.url"),
+		Headers:    config.GetStringMapString("data.http.headers"),
+		Interval:   config.GetDuration("data.http.interval"),
+		MaxBackoff: config.GetDuration("data.http.max_backoff"),
+		Timeout:    config.GetDuration("data.http.timeout"),
+	}
+
+	source, err := internal.NewHTTPDataSource(httpConfig)
+	if err != nil {
+		fatal("setting up http data source failed", slog.Any("error", err))
+	}
+	return source
+}
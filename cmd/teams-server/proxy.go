@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/pscheid/teams/auth"
+	"github.com/pscheid/teams/client"
+	"github.com/pscheid/teams/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/signal"
+	"syscall"
+)
+
+func buildProxyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "proxy",
+		Short: "Forward requests of members of the allowed teams to an upstream",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			serveProxy(loadConfig())
+		},
+	}
+}
+
+func serveProxy(config *viper.Viper) {
+	upstream, err := url.Parse(config.GetString("proxy.upstream"))
+	if err != nil || upstream.Scheme == "" || upstream.Host == "" {
+		fatal("invalid proxy upstream", slog.String("upstream", config.GetString("proxy.upstream")))
+	}
+
+	gateway := buildGatewayConfig(config)
+	handler := auth.NewProxy(upstream, auth.ProxyConfig{
+		Verifier: buildVerifier(config),
+		Cookie:   gateway.Cookie,
+		Teams:    gateway.Teams,
+	})
+
+	address := config.GetString("proxy.address")
+	proxyServer := &http.Server{Addr: address, Handler: handler}
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	failed := make(chan error, 1)
+	go func() {
+		slog.Info("proxying", slog.String("address", address), slog.String("upstream", upstream.String()))
+		if err := proxyServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			failed <- err
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		fatal("proxy failed", slog.Any("error", err))
+	case <-signals.Done():
+	}
+
+	stopSignals()
+	slog.Info("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetDuration("shutdown.timeout"))
+	defer cancel()
+
+	if err := proxyServer.Shutdown(ctx); err != nil {
+		slog.Error("draining requests failed", slog.Any("error", err))
+	}
+}
+
+// buildVerifier asks the server if its url is configured, so removed users are rejected.
+// Otherwise tokens are verified with the jwt public key, or else the secret, and the teams are
+// those at login.
+func buildVerifier(config *viper.Viper) auth.Verifier {
+	serverURL := config.GetString("proxy.server_url")
+	if serverURL == "" {
+		verifier, err := auth.NewLocalVerifier(buildLocalJwtConfig(config))
+		if err != nil {
+			fatal("invalid proxy jwt config", slog.Any("error", err))
+		}
+		return verifier
+	}
+
+	teamsClient, err := client.New(client.Config{BaseURL: serverURL})
+	if err != nil {
+		fatal("invalid proxy server url", slog.Any("error", err))
+	}
+	return auth.NewRemoteVerifier(teamsClient, config.GetDuration("proxy.cache_ttl"))
+}
+
+func buildLocalJwtConfig(config *viper.Viper) auth.JwtConfig {
+	sub := config.Sub("jwt")
+
+	jwtConfig := auth.JwtConfig{
+		Issuer:   sub.GetString("issuer"),
+		Audience: sub.GetString("audience"),
+		Leeway:   sub.GetDuration("leeway"),
+	}
+	if publicKey := sub.GetString("public_key"); publicKey != "" {
+		keys, err := internal.ParsePublicKeys([]string{publicKey})
+		if err != nil {
+			fatal("invalid jwt public key", slog.Any("error", err))
+		}
+		jwtConfig.PublicKey = keys[0]
+	} else if secret := sub.GetString("secret"); secret != "" {
+		jwtConfig.Secret = []byte(secret)
+	} else {
+		fatal("missing jwt public key")
+	}
+	return jwtConfig
+}
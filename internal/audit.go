@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	AuditLogin        = "login"
+	AuditVerify       = "verify"
+	AuditTeamLookup   = "team_lookup"
+	AuditTeamPut      = "team_put"
+	AuditTeamDelete   = "team_delete"
+	AuditMemberAdd    = "member_add"
+	AuditMemberRemove = "member_remove"
+	AuditDataChange   = "data_change"
+
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+
+	AuditReasonInvalidRequest   = "invalid_request"
+	AuditReasonUnknownUser      = "unknown_user"
+	AuditReasonInvalidSignature = "invalid_signature"
+	AuditReasonStaleTimestamp   = "stale_timestamp"
+	AuditReasonInvalidToken     = "invalid_token"
+	AuditReasonUnknownTeam      = "unknown_team"
+	AuditReasonEmptyTeam        = "empty_team"
+	AuditReasonInternalError    = "internal_error"
+	AuditReasonRateLimited      = "rate_limited"
+	AuditReasonLockedOut        = "locked_out"
+)
+
+var ErrAuditQueryUnsupported = errors.New("none of the audit sinks can be queried")
+
+type AuditSink interface {
+	Write(event api.AuditEvent) error
+	Close() error
+}
+
+type AuditQuerier interface {
+	Query(filter api.AuditFilter) ([]api.AuditEvent, error)
+}
+
+// AuditLog hands every event to all of its sinks. A nil *AuditLog discards events, so callers do
+// not need to check whether auditing is enabled.
+type AuditLog struct {
+	sinks         []AuditSink
+	unsubscribers []func()
+	done          sync.WaitGroup
+}
+
+func NewAuditLog(sinks ...AuditSink) *AuditLog {
+	return &AuditLog{sinks: sinks}
+}
+
+func (a *AuditLog) Record(event api.AuditEvent) {
+	if a == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	for _, sink := range a.sinks {
+		if err := sink.Write(event); err != nil {
+			slog.Error("writing audit event failed", slog.String("action", event.Action), slog.Any("error", err))
+		}
+	}
+}
+
+// RecordChanges records every change published by the source as a data change event.
+func (a *AuditLog) RecordChanges(source ChangeSubscriber) {
+	changes, unsubscribe := source.Subscribe()
+	a.unsubscribers = append(a.unsubscribers, unsubscribe)
+
+	a.done.Add(1)
+	go func() {
+		defer a.done.Done()
+		for change := range changes {
+			a.Record(api.AuditEvent{Time: change.Time, Action: AuditDataChange, Outcome: AuditSuccess, Change: &change})
+		}
+	}()
+}
+
+func (a *AuditLog) Query(filter api.AuditFilter) ([]api.AuditEvent, error) {
+	for _, sink := range a.sinks {
+		if querier, ok := sink.(AuditQuerier); ok {
+			return querier.Query(filter)
+		}
+	}
+	return nil, ErrAuditQueryUnsupported
+}
+
+func (a *AuditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+
+	for _, unsubscribe := range a.unsubscribers {
+		unsubscribe()
+	}
+	a.done.Wait()
+
+	var errs []error
+	for _, sink := range a.sinks {
+		errs = append(errs, sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// WriterAuditSink writes events as JSON lines, for example to stdout.
+type WriterAuditSink struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterAuditSink(w io.Writer) *WriterAuditSink {
+	return &WriterAuditSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *WriterAuditSink) Write(event api.AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.encoder.Encode(event)
+}
+
+func (s *WriterAuditSink) Close() error {
+	return nil
+}
+
+type AuditFileConfig struct {
+	Path string
+	// MaxSize is the size in bytes after which the file is rotated.
+	MaxSize int64
+	// MaxFiles is the number of rotated files that are kept next to the current one.
+	MaxFiles int
+}
+
+// FileAuditSink writes events as JSON lines to a file. Once the file grows beyond MaxSize, it is
+// renamed to Path.1, with older files shifted to Path.2 and so on up to Path.MaxFiles.
+type FileAuditSink struct {
+	config AuditFileConfig
+	mutex  sync.Mutex
+	file   *os.File
+	size   int64
+}
+
+func NewFileAuditSink(config AuditFileConfig) (*FileAuditSink, error) {
+	if config.MaxSize <= 0 {
+		config.MaxSize = 10 << 20
+	}
+	if config.MaxFiles <= 0 {
+		config.MaxFiles = 5
+	}
+
+	s := &FileAuditSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileAuditSink) open() error {
+	file, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("open audit log: %w", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileAuditSink) Write(event api.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var rotateErr error
+	if s.size > 0 && s.size+int64(len(line)) > s.config.MaxSize {
+		rotateErr = s.rotate()
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+
+	// the current file is reopened even if shifting failed, so events are never dropped
+	err := s.shift()
+	return errors.Join(err, s.open())
+}
+
+func (s *FileAuditSink) shift() error {
+	_ = os.Remove(s.rotatedPath(s.config.MaxFiles))
+	for i := s.config.MaxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(s.rotatedPath(i), s.rotatedPath(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate audit log: %w", err)
+		}
+	}
+	if err := os.Rename(s.config.Path, s.rotatedPath(1)); err != nil {
+		return fmt.Errorf("rotate audit log: %w", err)
+	}
+	return nil
+}
+
+func (s *FileAuditSink) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", s.config.Path, i)
+}
+
+// Query reads the rotated files and the current file, oldest first.
+func (s *FileAuditSink) Query(filter api.AuditFilter) ([]api.AuditEvent, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	paths := make([]string, 0, s.config.MaxFiles+1)
+	for i := s.config.MaxFiles; i >= 1; i-- {
+		paths = append(paths, s.rotatedPath(i))
+	}
+	paths = append(paths, s.config.Path)
+
+	events := []api.AuditEvent{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("query audit log: %w", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			event := api.AuditEvent{}
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			if filter.Matches(event) {
+				events = append(events, event)
+			}
+		}
+		err = scanner.Err()
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("query audit log: %w", err)
+		}
+	}
+
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+	return events, nil
+}
+
+func (s *FileAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type staticSnapshot DataSnapshot
+
+func (s staticSnapshot) GetCurrent() DataSnapshot {
+	return DataSnapshot(s)
+}
+
+func TestFileAuditSinkRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := NewFileAuditSink(AuditFileConfig{Path: path, MaxSize: 300, MaxFiles: 2})
+	if err != nil {
+		t.Fatalf("create sink: %v", err)
+	}
+	defer sink.Close()
+
+	start := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 20; i++ {
+		event := api.AuditEvent{Time: start.Add(time.Duration(i) * time.Minute), Action: AuditLogin, Outcome: AuditSuccess, User: fmt.Sprintf("user-%d", i%2)}
+		if err := sink.Write(event); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %s to exist: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("expected only two rotated files to be kept")
+	}
+
+	events, err := sink.Query(api.AuditFilter{User: "user-1", Since: start.Add(15 * time.Minute), Limit: 2})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(events) != 2 || !events[0].Time.Equal(start.Add(17*time.Minute)) || !events[1].Time.Equal(start.Add(19*time.Minute)) {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestServerAuditsLogins(t *testing.T) {
+	pub, priv := newTestKey(t)
+
+	sink, err := NewFileAuditSink(AuditFileConfig{Path: filepath.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatalf("create sink: %v", err)
+	}
+	audit := NewAuditLog(sink)
+	defer audit.Close()
+
+	server, jwt := newTestServer(t, ServerConfig{}, NewSnapshotDataRepository(staticSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": pub, "admin": {1}, "reader": {2}},
+		Roles: map[string][]Role{"admin": {{Name: RoleAdmin}}, "reader": {{Name: RoleReader}}},
+		Teams: map[string][]string{},
+	}))
+	server.InitAudit(audit)
+
+	login := func(username string, timestamp time.Time, key ed25519.PrivateKey) int {
+		body, _ := json.Marshal(api.LoginRequest{Username: username, Timestamp: timestamp, Challenge: api.CreateChallenge(username, timestamp, key)})
+		request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	_, other := newTestKey(t)
+	now := time.Now()
+	logins := []struct {
+		username  string
+		timestamp time.Time
+		key       ed25519.PrivateKey
+		code      int
+	}{
+		{"bob", now, priv, http.StatusNotFound},
+		{"alice", now, other, http.StatusUnauthorized},
+		{"alice", now.Add(-time.Hour), priv, http.StatusUnauthorized},
+		{"alice", now.Add(time.Hour), priv, http.StatusUnauthorized},
+		{"alice", now, priv, http.StatusOK},
+	}
+	for _, l := range logins {
+		if code := login(l.username, l.timestamp, l.key); code != l.code {
+			t.Errorf("login %s at %s: expected %d, got %d", l.username, l.timestamp, l.code, code)
+		}
+	}
+
+	queryAudit := func(username string) *httptest.ResponseRecorder {
+		token, _ := jwt.Create(username, nil, nil, time.Now())
+		request := httptest.NewRequest(http.MethodGet, "/audit?user=alice", nil)
+		request.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if recorder := queryAudit("reader"); recorder.Code != http.StatusForbidden {
+		t.Errorf("expected audit log to be restricted to admins, got %d", recorder.Code)
+	}
+
+	recorder := queryAudit("admin")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected audit log, got %d", recorder.Code)
+	}
+	response := api.AuditResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+
+	expected := []string{AuditReasonInvalidSignature, AuditReasonStaleTimestamp, AuditReasonStaleTimestamp, ""}
+	if len(response.Events) != len(expected) {
+		t.Fatalf("expected %d events, got %+v", len(expected), response.Events)
+	}
+	for i, event := range response.Events {
+		if event.Action != AuditLogin || event.Reason != expected[i] {
+			t.Errorf("event %d: expected login with reason %q, got %+v", i, expected[i], event)
+		}
+	}
+	if response.Events[3].Outcome != AuditSuccess {
+		t.Errorf("expected successful login, got %+v", response.Events[3])
+	}
+}
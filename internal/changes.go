@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+const changeHistorySize = 100
+
+func DiffSnapshots(previous DataSnapshot, current DataSnapshot) api.DataChange {
+	change := api.DataChange{
+		PreviousRevision: previous.Revision,
+		Revision:         current.Revision,
+		MembersAdded:     make(map[string][]string),
+		MembersRemoved:   make(map[string][]string),
+	}
+
+	for _, name := range sortedKeys(current.Users) {
+		previousKey, ok := previous.Users[name]
+		switch {
+		case !ok:
+			change.UsersAdded = append(change.UsersAdded, name)
+		case !bytes.Equal(previousKey, current.Users[name]):
+			change.KeysChanged = append(change.KeysChanged, name)
+		}
+		if ok && !slices.Equal(previous.Roles[name], current.Roles[name]) {
+			change.RolesChanged = append(change.RolesChanged, name)
+		}
+	}
+	for _, name := range sortedKeys(previous.Users) {
+		if _, ok := current.Users[name]; !ok {
+			change.UsersRemoved = append(change.UsersRemoved, name)
+		}
+	}
+
+	for _, team := range sortedKeys(current.Teams) {
+		previousMembers, ok := previous.Teams[team]
+		if !ok {
+			change.TeamsAdded = append(change.TeamsAdded, team)
+		}
+		if added := missingFrom(current.Teams[team], previousMembers); len(added) > 0 {
+			change.MembersAdded[team] = added
+		}
+		if removed := missingFrom(previousMembers, current.Teams[team]); len(removed) > 0 {
+			change.MembersRemoved[team] = removed
+		}
+	}
+	for _, team := range sortedKeys(previous.Teams) {
+		if _, ok := current.Teams[team]; !ok {
+			change.TeamsRemoved = append(change.TeamsRemoved, team)
+			change.MembersRemoved[team] = slices.Sorted(slices.Values(previous.Teams[team]))
+		}
+	}
+
+	return change
+}
+
+func changeLogAttributes(c api.DataChange) []any {
+	attributes := []any{slog.String("previous_revision", c.PreviousRevision), slog.String("revision", c.Revision)}
+	add := func(key string, values []string) {
+		if len(values) > 0 {
+			attributes = append(attributes, slog.Any(key, values))
+		}
+	}
+	add("users_added", c.UsersAdded)
+	add("users_removed", c.UsersRemoved)
+	add("keys_changed", c.KeysChanged)
+	add("roles_changed", c.RolesChanged)
+	add("teams_added", c.TeamsAdded)
+	add("teams_removed", c.TeamsRemoved)
+	if len(c.MembersAdded) > 0 {
+		attributes = append(attributes, slog.Any("members_added", c.MembersAdded))
+	}
+	if len(c.MembersRemoved) > 0 {
+		attributes = append(attributes, slog.Any("members_removed", c.MembersRemoved))
+	}
+	return attributes
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	return slices.Sorted(maps.Keys(m))
+}
+
+// missingFrom returns the sorted values that are not contained in other.
+func missingFrom(values []string, other []string) []string {
+	var missing []string
+	for _, value := range values {
+		if !slices.Contains(other, value) {
+			missing = append(missing, value)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+type ChangeReporter interface {
+	Changes() []api.DataChange
+}
+
+// changeTracker compares every snapshot a source takes with the previous one, keeps a short
+// history of the differences and hands them to subscribers.
+type changeTracker struct {
+	mutex       sync.Mutex
+	current     *DataSnapshot
+	history     []api.DataChange
+	subscribers map[*changeSubscription]struct{}
+}
+
+// changeSubscription queues changes until the subscriber receives them, so slow subscribers
+// neither block the source nor miss changes.
+type changeSubscription struct {
+	mutex sync.Mutex
+	queue []api.DataChange
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func (s *changeSubscription) push(change api.DataChange) {
+	s.mutex.Lock()
+	s.queue = append(s.queue, change)
+	s.mutex.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (s *changeSubscription) forward(out chan<- api.DataChange) {
+	defer close(out)
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.mutex.Unlock()
+			select {
+			case <-s.ready:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+		change := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		select {
+		case out <- change:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (t *changeTracker) track(snapshot DataSnapshot) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	previous := t.current
+	t.current = &snapshot
+	if previous == nil || (previous.Revision != "" && previous.Revision == snapshot.Revision) {
+		return
+	}
+
+	change := DiffSnapshots(*previous, snapshot)
+	if change.Empty() {
+		return
+	}
+	change.Time = time.Now()
+
+	slog.Info("data changed", changeLogAttributes(change)...)
+
+	t.history = append(t.history, change)
+	if len(t.history) > changeHistorySize {
+		t.history = slices.Delete(t.history, 0, len(t.history)-changeHistorySize)
+	}
+
+	for subscription := range t.subscribers {
+		subscription.push(change)
+	}
+}
+
+// Subscribe returns a channel receiving every future change in order. The returned function ends
+// the subscription and closes the channel.
+func (t *changeTracker) Subscribe() (<-chan api.DataChange, func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.subscribers == nil {
+		t.subscribers = make(map[*changeSubscription]struct{})
+	}
+	subscription := &changeSubscription{ready: make(chan struct{}, 1), done: make(chan struct{})}
+	t.subscribers[subscription] = struct{}{}
+
+	out := make(chan api.DataChange)
+	go subscription.forward(out)
+
+	var once sync.Once
+	return out, func() {
+		once.Do(func() {
+			t.mutex.Lock()
+			defer t.mutex.Unlock()
+			delete(t.subscribers, subscription)
+			close(subscription.done)
+		})
+	}
+}
+
+func (t *changeTracker) Changes() []api.DataChange {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return slices.Clone(t.history)
+}
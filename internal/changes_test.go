@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	previous := DataSnapshot{
+		Revision: "1",
+		Users: map[string]ed25519.PublicKey{
+			"alice": {1}, "bob": {2}, "carol": {3},
+		},
+		Roles: map[string][]Role{"alice": {{Name: RoleAdmin}}},
+		Teams: map[string][]string{
+			"team-1": {"alice", "bob"},
+			"team-2": {"carol"},
+		},
+	}
+	current := DataSnapshot{
+		Revision: "2",
+		Users: map[string]ed25519.PublicKey{
+			"alice": {1}, "bob": {4}, "dave": {5},
+		},
+		Roles: map[string][]Role{"alice": {{Name: RoleReader}}},
+		Teams: map[string][]string{
+			"team-1": {"alice", "dave"},
+			"team-3": {"bob"},
+		},
+	}
+
+	expected := api.DataChange{
+		PreviousRevision: "1",
+		Revision:         "2",
+		UsersAdded:       []string{"dave"},
+		UsersRemoved:     []string{"carol"},
+		KeysChanged:      []string{"bob"},
+		RolesChanged:     []string{"alice"},
+		TeamsAdded:       []string{"team-3"},
+		TeamsRemoved:     []string{"team-2"},
+		MembersAdded:     map[string][]string{"team-1": {"dave"}, "team-3": {"bob"}},
+		MembersRemoved:   map[string][]string{"team-1": {"bob"}, "team-2": {"carol"}},
+	}
+
+	change := DiffSnapshots(previous, current)
+	if !reflect.DeepEqual(change, expected) {
+		t.Errorf("unexpected change\n got: %+v\nwant: %+v", change, expected)
+	}
+
+	if !DiffSnapshots(current, current).Empty() {
+		t.Error("expected no change between equal snapshots")
+	}
+}
+
+func TestDataMonitorPublishesChanges(t *testing.T) {
+	key := testUserKey(t)
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	writeTestFile(t, path, testDataFile(key, "team-1"))
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	changes, unsubscribe := monitor.Subscribe()
+	defer unsubscribe()
+
+	writeTestFile(t, path, testDataFile(key, "team-2"))
+
+	select {
+	case change := <-changes:
+		if !reflect.DeepEqual(change.TeamsAdded, []string{"team-2"}) || !reflect.DeepEqual(change.TeamsRemoved, []string{"team-1"}) {
+			t.Errorf("unexpected change %+v", change)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected change to be published")
+	}
+
+	server := NewServer(ServerConfig{}, nil, NewYAMLFileDataRepository(monitor))
+	server.InitRoutes()
+
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/changes", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected history, got %d", recorder.Code)
+	}
+
+	response := api.ChangesResponse{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(response.Changes) != 1 || !reflect.DeepEqual(response.Changes[0].MembersAdded, map[string][]string{"team-2": {"alice"}}) {
+		t.Errorf("unexpected history %+v", response.Changes)
+	}
+}
@@ -0,0 +1,391 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+var dataFileExtensions = []string{".yaml", ".yml", ".json"}
+
+var ErrNoDataFiles = errors.New("no data files found")
+
+type dataFileUser struct {
+	Name  string   `yaml:"name"`
+	Key   string   `yaml:"key"`
+	Roles []string `yaml:"roles,omitempty"`
+}
+
+type dataFileContent struct {
+	Teams map[string][]string `yaml:"teams"`
+	Users []dataFileUser      `yaml:"users"`
+}
+
+type dataFile struct {
+	path     string
+	users    []dataFileEntry
+	teams    []dataFileEntry
+	problems []ValidationProblem
+}
+
+// dataFileEntry is a user or team definition together with the position it was read from.
+type dataFileEntry struct {
+	name    string
+	line    int
+	key     string
+	roles   []string
+	members []dataFileMember
+}
+
+type dataFileMember struct {
+	name string
+	line int
+}
+
+// ResolveDataFiles expands every pattern into data files. A pattern is either a file, a directory
+// whose data files are used, or a glob. Hidden files are skipped, which covers the bookkeeping
+// entries Kubernetes creates in mounted ConfigMaps.
+func ResolveDataFiles(patterns []string) ([]string, error) {
+	files := make([]string, 0, len(patterns))
+	add := func(path string) {
+		path = filepath.Clean(path)
+		if !slices.Contains(files, path) {
+			files = append(files, path)
+		}
+	}
+
+	for _, pattern := range patterns {
+		if isGlob(pattern) {
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("resolve data files %q: %w", pattern, err)
+			}
+			slices.Sort(matches)
+			for _, match := range matches {
+				if isDataFile(match) {
+					add(match)
+				}
+			}
+			continue
+		}
+
+		info, err := os.Stat(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("resolve data files: %w", err)
+		}
+
+		if !info.IsDir() {
+			add(pattern)
+			continue
+		}
+
+		entries, err := os.ReadDir(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("resolve data files: %w", err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(pattern, entry.Name())
+			if isDataFile(path) {
+				add(path)
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, ErrNoDataFiles
+	}
+	return files, nil
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func isDataFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || !slices.Contains(dataFileExtensions, filepath.Ext(name)) {
+		return false
+	}
+
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// LoadDataFiles accepts what the server always accepted, like empty teams. ValidateDataFiles also
+// reports those, so new problems do not keep servers from starting.
+func LoadDataFiles(patterns []string) (DataSnapshot, error) {
+	return loadDataFiles(patterns, false)
+}
+
+func ValidateDataFiles(patterns []string) (DataSnapshot, error) {
+	return loadDataFiles(patterns, true)
+}
+
+func loadDataFiles(patterns []string, strict bool) (DataSnapshot, error) {
+	paths, err := ResolveDataFiles(patterns)
+	if err != nil {
+		return DataSnapshot{}, err
+	}
+
+	files := make([]dataFile, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return DataSnapshot{}, err
+		}
+		files = append(files, decodeDataFile(path, content))
+	}
+
+	return mergeDataFiles(files, strict)
+}
+
+// teamName normalizes team names in data files. They are case insensitive, as they were when viper
+// read the files.
+func teamName(name string) string {
+	return strings.ToLower(name)
+}
+
+func parseDataFile(path string, content []byte) (DataSnapshot, error) {
+	return mergeDataFiles([]dataFile{decodeDataFile(path, content)}, false)
+}
+
+// decodeDataFile reads the structure of a data file. Problems are recorded on the returned file
+// instead of aborting, so a single validation run reports as many of them as possible.
+func decodeDataFile(path string, content []byte) dataFile {
+	file := dataFile{path: path}
+	problem := func(line int, format string, args ...any) {
+		file.problems = append(file.problems, ValidationProblem{File: path, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		problem(0, "%s", strings.TrimPrefix(err.Error(), "yaml: "))
+		return file
+	}
+	if len(document.Content) == 0 || isNull(document.Content[0]) {
+		return file
+	}
+
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		problem(root.Line, "expected a mapping with users and teams")
+		return file
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch strings.ToLower(key.Value) {
+		case "users":
+			file.users = decodeUsers(value, problem)
+		case "teams":
+			file.teams = decodeTeams(value, problem)
+		}
+	}
+
+	return file
+}
+
+func decodeUsers(node *yaml.Node, problem func(line int, format string, args ...any)) []dataFileEntry {
+	if isNull(node) {
+		return nil
+	}
+	if node.Kind != yaml.SequenceNode {
+		problem(node.Line, "users must be a list")
+		return nil
+	}
+
+	users := make([]dataFileEntry, 0, len(node.Content))
+	for _, item := range node.Content {
+		user := dataFileUser{}
+		if err := item.Decode(&user); err != nil {
+			problem(item.Line, "invalid user: %s", strings.TrimPrefix(err.Error(), "yaml: "))
+			continue
+		}
+		if user.Name == "" {
+			problem(item.Line, "user without name")
+			continue
+		}
+		users = append(users, dataFileEntry{name: user.Name, line: item.Line, key: user.Key, roles: user.Roles})
+	}
+	return users
+}
+
+func decodeTeams(node *yaml.Node, problem func(line int, format string, args ...any)) []dataFileEntry {
+	if isNull(node) {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		problem(node.Line, "teams must be a mapping of team names to members")
+		return nil
+	}
+
+	teams := make([]dataFileEntry, 0, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		team := dataFileEntry{name: teamName(key.Value), line: key.Line}
+
+		if !isNull(value) && value.Kind != yaml.SequenceNode {
+			problem(value.Line, "team %s: members must be a list", team.name)
+			continue
+		}
+		for _, item := range value.Content {
+			if item.Kind != yaml.ScalarNode {
+				problem(item.Line, "team %s: member must be a username", team.name)
+				continue
+			}
+			team.members = append(team.members, dataFileMember{name: item.Value, line: item.Line})
+		}
+		teams = append(teams, team)
+	}
+	return teams
+}
+
+func isNull(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+// mergeDataFiles combines the files into one snapshot. All problems found in the files are
+// returned together as a *ValidationError. Empty teams and repeated members are only problems if
+// strict, keys of the wrong size always are, as verifying logins with them panics.
+func mergeDataFiles(files []dataFile, strict bool) (DataSnapshot, error) {
+	type origin struct {
+		file string
+		line int
+	}
+
+	var problems []ValidationProblem
+	problem := func(file string, line int, format string, args ...any) {
+		problems = append(problems, ValidationProblem{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	userOrigins := make(map[string]origin)
+	teamOrigins := make(map[string]origin)
+
+	users := make(map[string]ed25519.PublicKey)
+	roles := make(map[string][]Role)
+	teams := make(map[string][]string)
+
+	for _, file := range files {
+		problems = append(problems, file.problems...)
+
+		for _, u := range file.users {
+			if previous, ok := userOrigins[u.name]; ok {
+				problem(file.path, u.line, "user %s is already defined at %s:%d", u.name, previous.file, previous.line)
+				continue
+			}
+			userOrigins[u.name] = origin{file.path, u.line}
+
+			key, err := base64.StdEncoding.DecodeString(u.key)
+			switch {
+			case err != nil:
+				problem(file.path, u.line, "user %s: key is not valid base64: %v", u.name, err)
+			case len(key) != ed25519.PublicKeySize:
+				problem(file.path, u.line, "user %s: key has %d bytes, expected %d", u.name, len(key), ed25519.PublicKeySize)
+			}
+			users[u.name] = key
+
+			userRoles, err := ParseRoles(u.roles)
+			if err != nil {
+				problem(file.path, u.line, "user %s: %v", u.name, err)
+			}
+			roles[u.name] = userRoles
+		}
+	}
+
+	for _, file := range files {
+		for _, t := range file.teams {
+			if previous, ok := teamOrigins[t.name]; ok {
+				problem(file.path, t.line, "team %s is already defined at %s:%d", t.name, previous.file, previous.line)
+				continue
+			}
+			teamOrigins[t.name] = origin{file.path, t.line}
+
+			if strict && len(t.members) == 0 {
+				problem(file.path, t.line, "team %s has no members", t.name)
+			}
+
+			members := make([]string, 0, len(t.members))
+			for _, m := range t.members {
+				if _, ok := users[m.name]; !ok {
+					problem(file.path, m.line, "team %s: member %s is not a defined user", t.name, m.name)
+				}
+				if slices.Contains(members, m.name) {
+					if strict {
+						problem(file.path, m.line, "team %s: member %s is listed more than once", t.name, m.name)
+					}
+					continue
+				}
+				members = append(members, m.name)
+			}
+			teams[t.name] = members
+		}
+	}
+
+	if len(problems) > 0 {
+		return DataSnapshot{}, &ValidationError{Problems: problems}
+	}
+	return DataSnapshot{Users: users, Roles: roles, Teams: teams}, nil
+}
+
+func writeDataFile(path string, snapshot DataSnapshot) error {
+	content := dataFileContent{
+		Teams: snapshot.Teams,
+		Users: make([]dataFileUser, 0, len(snapshot.Users)),
+	}
+	for name, key := range snapshot.Users {
+		content.Users = append(content.Users, dataFileUser{
+			Name:  name,
+			Key:   base64.StdEncoding.EncodeToString(key),
+			Roles: formatRoles(snapshot.Roles[name]),
+		})
+	}
+	slices.SortFunc(content.Users, func(a, b dataFileUser) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	buffer := bytes.Buffer{}
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(content); err != nil {
+		return err
+	}
+
+	// the file is only replaced by content the server can load again
+	if _, err := parseDataFile(path, buffer.Bytes()); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	// write to a sibling file and rename it, so the watcher never observes a partially written file
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(buffer.Bytes()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
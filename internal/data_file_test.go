@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func testUserKey(t *testing.T) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+func TestResolveDataFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "teams", "b.yaml"), "")
+	writeTestFile(t, filepath.Join(dir, "teams", "a.yml"), "")
+	writeTestFile(t, filepath.Join(dir, "teams", ".hidden.yaml"), "")
+	writeTestFile(t, filepath.Join(dir, "teams", "notes.txt"), "")
+	writeTestFile(t, filepath.Join(dir, "users.yaml"), "")
+
+	files, err := ResolveDataFiles([]string{filepath.Join(dir, "teams"), filepath.Join(dir, "*.yaml"), filepath.Join(dir, "teams", "a.yml")})
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+
+	expected := []string{
+		filepath.Join(dir, "teams", "a.yml"),
+		filepath.Join(dir, "teams", "b.yaml"),
+		filepath.Join(dir, "users.yaml"),
+	}
+	if !slices.Equal(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	if _, err := ResolveDataFiles([]string{filepath.Join(dir, "*.json")}); err != ErrNoDataFiles {
+		t.Errorf("expected no data files error, got %v", err)
+	}
+}
+
+func TestLoadDataFilesMergesFiles(t *testing.T) {
+	key := testUserKey(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "users.yaml"), "users:\n  - name: alice\n    key: "+key+"\n")
+	writeTestFile(t, filepath.Join(dir, "team-1.yaml"), "teams:\n  team-1: [alice, bob]\nusers:\n  - name: bob\n    key: "+key+"\n")
+
+	snapshot, err := LoadDataFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(snapshot.Users) != 2 || !slices.Equal(snapshot.Teams["team-1"], []string{"alice", "bob"}) {
+		t.Errorf("expected merged snapshot, got %+v", snapshot)
+	}
+
+	t.Run("duplicate definitions", func(t *testing.T) {
+		writeTestFile(t, filepath.Join(dir, "team-2.yaml"), "teams:\n  team-1: [alice]\n")
+
+		_, err := LoadDataFiles([]string{dir})
+		if err == nil {
+			t.Fatal("expected duplicate team to be rejected")
+		}
+		for _, name := range []string{"team-1.yaml", "team-2.yaml"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("expected error to name %s, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestDataMonitorWatchesDirectory(t *testing.T) {
+	key := testUserKey(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "users.yaml"), "users:\n  - name: alice\n    key: "+key+"\n")
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{dir}})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	if monitor.Writable() {
+		t.Error("expected directory to be read-only")
+	}
+
+	writeTestFile(t, filepath.Join(dir, "team-1.yaml"), "teams:\n  team-1: [alice]\n")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, found := monitor.GetCurrent().Teams["team-1"]; found {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected new file to be loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadDataFilesReportsAllProblems(t *testing.T) {
+	key := testUserKey(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.yaml"), `users:
+  - name: alice
+    key: `+key+`
+  - name: bob
+    key: not-base64!
+  - name: carol
+    key: AAAA
+teams:
+  team-1:
+    - alice
+    - dave
+  team-2: []
+`)
+	writeTestFile(t, filepath.Join(dir, "b.yaml"), `users:
+  - name: alice
+    key: `+key+`
+`)
+
+	_, err := ValidateDataFiles([]string{dir})
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected validation error, got %v", err)
+	}
+
+	a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+	expected := []string{
+		a + ":4: user bob: key is not valid base64: illegal base64 data at input byte 3",
+		a + ":6: user carol: key has 3 bytes, expected 32",
+		b + ":2: user alice is already defined at " + a + ":2",
+		a + ":11: team team-1: member dave is not a defined user",
+		a + ":12: team team-2 has no members",
+	}
+
+	actual := make([]string, 0, len(validationErr.Problems))
+	for _, p := range validationErr.Problems {
+		actual = append(actual, p.String())
+	}
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected problems\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+	}
+}
+
+func TestLoadDataFilesIsLenient(t *testing.T) {
+	key := testUserKey(t)
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.yaml"), `Users:
+  - name: alice
+    key: `+key+`
+Teams:
+  Team-1: [alice, alice]
+  team-2: []
+`)
+
+	snapshot, err := LoadDataFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if members := snapshot.Teams["team-1"]; !slices.Equal(members, []string{"alice"}) {
+		t.Errorf("expected lowercased team with alice once, got %v", snapshot.Teams)
+	}
+	if _, found := snapshot.Teams["team-2"]; !found {
+		t.Errorf("expected the empty team to be kept, got %v", snapshot.Teams)
+	}
+
+	if _, err := ValidateDataFiles([]string{dir}); err == nil {
+		t.Error("expected validation to report the empty team and the repeated member")
+	}
+}
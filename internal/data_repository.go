@@ -2,40 +2,190 @@ package internal
 
 import (
 	"crypto/ed25519"
+	"github.com/pscheid/teams/api"
+	"slices"
 )
 
 type DataRepository interface {
 	UserExists(username string) bool
 	GetUserPublicKey(username string) (ed25519.PublicKey, bool)
+	GetUserRoles(username string) ([]Role, bool)
 	GetTeamMembers(team string) ([]string, bool)
+	// GetUserTeams returns the sorted names of the teams the user is a member of.
+	GetUserTeams(username string) []string
 }
 
-type YAMLFileDataRepository struct {
-	monitor *DataMonitor
+type WritableDataRepository interface {
+	DataRepository
+	PutTeam(team string, members []string) error
+	DeleteTeam(team string) error
+	AddTeamMember(team string, username string) error
+	RemoveTeamMember(team string, username string) error
 }
 
-func NewYAMLFileDataRepository(monitor *DataMonitor) *YAMLFileDataRepository {
-	return &YAMLFileDataRepository{monitor: monitor}
+type SnapshotSource interface {
+	GetCurrent() DataSnapshot
+}
+
+type SnapshotDataRepository struct {
+	source SnapshotSource
+}
+
+func NewSnapshotDataRepository(source SnapshotSource) *SnapshotDataRepository {
+	return &SnapshotDataRepository{source: source}
+}
+
+func (r *SnapshotDataRepository) Snapshot() DataSnapshot {
+	return r.source.GetCurrent()
+}
+
+func (r *SnapshotDataRepository) Status() ReloadStatus {
+	if reporter, ok := r.source.(StatusReporter); ok {
+		return reporter.Status()
+	}
+
+	snapshot := r.Snapshot()
+	return ReloadStatus{Revision: snapshot.Revision, Users: len(snapshot.Users), Teams: len(snapshot.Teams)}
 }
 
-func (r *YAMLFileDataRepository) Snapshot() DataSnapshot {
-	return r.monitor.GetCurrent()
+func (r *SnapshotDataRepository) Changes() []api.DataChange {
+	if reporter, ok := r.source.(ChangeReporter); ok {
+		return reporter.Changes()
+	}
+	return nil
 }
 
-func (r *YAMLFileDataRepository) UserExists(username string) bool {
+func (r *SnapshotDataRepository) UserExists(username string) bool {
 	snapshot := r.Snapshot()
 	_, ok := snapshot.Users[username]
 	return ok
 }
 
-func (r *YAMLFileDataRepository) GetUserPublicKey(username string) (ed25519.PublicKey, bool) {
+func (r *SnapshotDataRepository) GetUserPublicKey(username string) (ed25519.PublicKey, bool) {
 	snapshot := r.Snapshot()
 	key, ok := snapshot.Users[username]
 	return key, ok
 }
 
-func (r *YAMLFileDataRepository) GetTeamMembers(team string) ([]string, bool) {
+func (r *SnapshotDataRepository) GetUserRoles(username string) ([]Role, bool) {
 	snapshot := r.Snapshot()
-	members, ok := snapshot.Teams[team]
+	if _, ok := snapshot.Users[username]; !ok {
+		return nil, false
+	}
+	return snapshot.Roles[username], true
+}
+
+func (r *SnapshotDataRepository) GetTeamMembers(team string) ([]string, bool) {
+	snapshot := r.Snapshot()
+	if members, ok := snapshot.Teams[team]; ok {
+		return members, true
+	}
+	// data files lowercase team names
+	members, ok := snapshot.Teams[teamName(team)]
 	return members, ok
 }
+
+func (r *SnapshotDataRepository) GetUserTeams(username string) []string {
+	snapshot := r.Snapshot()
+	teams := make([]string, 0)
+	for team, members := range snapshot.Teams {
+		if slices.Contains(members, username) {
+			teams = append(teams, team)
+		}
+	}
+	slices.Sort(teams)
+	return teams
+}
+
+type YAMLFileDataRepository struct {
+	*SnapshotDataRepository
+	monitor *DataMonitor
+}
+
+func NewYAMLFileDataRepository(monitor *DataMonitor) *YAMLFileDataRepository {
+	return &YAMLFileDataRepository{
+		SnapshotDataRepository: NewSnapshotDataRepository(monitor),
+		monitor:                monitor,
+	}
+}
+
+func (r *YAMLFileDataRepository) PutTeam(team string, members []string) error {
+	return r.monitor.Update(func(snapshot *DataSnapshot) error {
+		return putTeam(snapshot, teamName(team), members)
+	})
+}
+
+func (r *YAMLFileDataRepository) DeleteTeam(team string) error {
+	return r.monitor.Update(func(snapshot *DataSnapshot) error {
+		return deleteTeam(snapshot, teamName(team))
+	})
+}
+
+func (r *YAMLFileDataRepository) AddTeamMember(team string, username string) error {
+	return r.monitor.Update(func(snapshot *DataSnapshot) error {
+		return addTeamMember(snapshot, teamName(team), username)
+	})
+}
+
+func (r *YAMLFileDataRepository) RemoveTeamMember(team string, username string) error {
+	return r.monitor.Update(func(snapshot *DataSnapshot) error {
+		return removeTeamMember(snapshot, teamName(team), username)
+	})
+}
+
+func putTeam(snapshot *DataSnapshot, team string, members []string) error {
+	if len(members) == 0 {
+		return api.ErrEmptyTeam
+	}
+
+	unique := make([]string, 0, len(members))
+	for _, username := range members {
+		if _, ok := snapshot.Users[username]; !ok {
+			return api.ErrUnknownUser
+		}
+		if !slices.Contains(unique, username) {
+			unique = append(unique, username)
+		}
+	}
+
+	snapshot.Teams[team] = unique
+	return nil
+}
+
+func deleteTeam(snapshot *DataSnapshot, team string) error {
+	if _, ok := snapshot.Teams[team]; !ok {
+		return api.ErrUnknownTeam
+	}
+	delete(snapshot.Teams, team)
+	return nil
+}
+
+func addTeamMember(snapshot *DataSnapshot, team string, username string) error {
+	members, ok := snapshot.Teams[team]
+	if !ok {
+		return api.ErrUnknownTeam
+	}
+	if _, ok := snapshot.Users[username]; !ok {
+		return api.ErrUnknownUser
+	}
+
+	if !slices.Contains(members, username) {
+		snapshot.Teams[team] = append(members, username)
+	}
+	return nil
+}
+
+func removeTeamMember(snapshot *DataSnapshot, team string, username string) error {
+	members, ok := snapshot.Teams[team]
+	if !ok {
+		return api.ErrUnknownTeam
+	}
+
+	remaining := slices.DeleteFunc(members, func(m string) bool { return m == username })
+	if len(remaining) == 0 {
+		return api.ErrEmptyTeam
+	}
+
+	snapshot.Teams[team] = remaining
+	return nil
+}
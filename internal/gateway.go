@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pscheid/teams/api"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type GatewayConfig struct {
+	// Cookie is read by GET /forward-auth when there is no bearer token, and set by logins, so
+	// browsers can send the access token.
+	Cookie string
+	// Teams whose members GET /forward-auth admits. Any user with a valid token is if it is empty.
+	Teams []string
+	// TeamQuery lets the team query parameters narrow Teams, so one server can guard several
+	// sites. Teams that are not configured are ignored, as a request could name any team.
+	TeamQuery bool
+}
+
+// buildForwardAuthHandler answers subrequests of nginx auth_request and traefik ForwardAuth. The
+// teams of the user are read from the repository, so removed members are rejected right away.
+func (s *Server) buildForwardAuthHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		queried := c.QueryParams()["team"]
+		if len(queried) > 0 && !s.config.Gateway.TeamQuery {
+			return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "team query parameters are not enabled")
+		}
+
+		accessToken := api.AccessToken(c.Request(), s.config.Gateway.Cookie)
+		if accessToken == "" {
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemMissingToken, "")
+		}
+
+		ctx := c.Request().Context()
+
+		span := startSpan(ctx, "JwtHelper.Validate")
+		claims, err := s.jwt.Validate(accessToken)
+		endSpan(span, err)
+		if err != nil {
+			return writeProblem(c, http.StatusUnauthorized, tokenProblem(err), "")
+		}
+
+		username := claims.Subject
+		user := attribute.String("teams.user", username)
+		span = startSpan(ctx, "DataRepository.UserExists", user)
+		found := s.repository.UserExists(username)
+		span.End()
+		if !found {
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemInvalidToken, "the owner of the token no longer exists")
+		}
+
+		span = startSpan(ctx, "DataRepository.GetUserTeams", user)
+		teams := s.repository.GetUserTeams(username)
+		span.End()
+
+		if !s.admits(teams, queried) {
+			return writeProblem(c, http.StatusForbidden, api.ProblemForbidden, "the user is not a member of an allowed team")
+		}
+
+		c.Response().Header().Set(api.HeaderAuthUser, username)
+		c.Response().Header().Set(api.HeaderAuthTeams, strings.Join(teams, ","))
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+// admits reports whether the user is a member of an allowed team. Queried teams narrow the
+// configured ones, which only allow any team if none are configured.
+func (s *Server) admits(teams []string, queried []string) bool {
+	configured := s.config.Gateway.Teams
+	if len(queried) == 0 {
+		return api.MemberOfAny(teams, configured)
+	}
+	return slices.ContainsFunc(queried, func(team string) bool {
+		return slices.Contains(teams, team) && (len(configured) == 0 || slices.Contains(configured, team))
+	})
+}
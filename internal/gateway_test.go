@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestForwardAuth(t *testing.T) {
+	repository := NewSnapshotDataRepository(staticSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": {1}, "bob": {1}},
+		Teams: map[string][]string{"team-1": {"alice"}, "team-2": {"alice", "bob"}},
+	})
+	server, jwt := newTestServer(t, ServerConfig{Gateway: GatewayConfig{Cookie: "teams_token", Teams: []string{"team-1"}}}, repository)
+	queryServer, _ := newTestServer(t, ServerConfig{Gateway: GatewayConfig{Cookie: "teams_token", Teams: []string{"team-1"}, TeamQuery: true}}, repository)
+
+	alice, _ := jwt.Create("alice", nil, nil, time.Now())
+	bob, _ := jwt.Create("bob", nil, nil, time.Now())
+	removed, _ := jwt.Create("carol", nil, []string{"team-1"}, time.Now())
+
+	requests := []struct {
+		server *Server
+		path   string
+		header string
+		cookie string
+		status int
+		teams  string
+	}{
+		{server, "/forward-auth", "Bearer " + alice, "", http.StatusOK, "team-1,team-2"},
+		{server, "/forward-auth", "", alice, http.StatusOK, "team-1,team-2"},
+		{server, "/forward-auth", "", bob, http.StatusForbidden, ""},
+		{server, "/forward-auth?team=team-2", "", bob, http.StatusBadRequest, ""},
+		{queryServer, "/forward-auth?team=team-3&team=team-2", "", bob, http.StatusForbidden, ""},
+		{queryServer, "/forward-auth?team=team-2", "", alice, http.StatusForbidden, ""},
+		{queryServer, "/forward-auth?team=team-1", "", alice, http.StatusOK, "team-1,team-2"},
+		{server, "/forward-auth", "Bearer " + removed, "", http.StatusUnauthorized, ""},
+		{server, "/forward-auth", "Basic YWxpY2U6", "", http.StatusUnauthorized, ""},
+	}
+	for i, r := range requests {
+		request := httptest.NewRequest(http.MethodGet, r.path, nil)
+		if r.header != "" {
+			request.Header.Set("Authorization", r.header)
+		}
+		if r.cookie != "" {
+			request.AddCookie(&http.Cookie{Name: "teams_token", Value: r.cookie})
+		}
+		recorder := httptest.NewRecorder()
+		r.server.ServeHTTP(recorder, request)
+
+		if recorder.Code != r.status || recorder.Header().Get(api.HeaderAuthTeams) != r.teams {
+			t.Errorf("%d: expected %d with teams %q, got %d with %q", i, r.status, r.teams, recorder.Code, recorder.Header().Get(api.HeaderAuthTeams))
+		}
+		if r.status == http.StatusOK && recorder.Header().Get(api.HeaderAuthUser) == "" {
+			t.Errorf("%d: expected the user header", i)
+		}
+	}
+}
+
+func TestLoginSetsGatewayCookie(t *testing.T) {
+	public, private := newTestKey(t)
+	server, _ := newTestServer(t, ServerConfig{Gateway: GatewayConfig{Cookie: "teams_token"}}, NewSnapshotDataRepository(staticSnapshot{Users: map[string]ed25519.PublicKey{"alice": public}}))
+
+	now := time.Now()
+	body, _ := json.Marshal(api.LoginRequest{Username: "alice", Timestamp: now, Challenge: api.CreateChallenge("alice", now, private)})
+	request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	response := api.LoginResponse{}
+	_ = json.Unmarshal(recorder.Body.Bytes(), &response)
+	cookies := recorder.Result().Cookies()
+	if recorder.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != "teams_token" || cookies[0].Value != response.AccessToken || !cookies[0].HttpOnly {
+		t.Errorf("expected the access token in the cookie, got %d %v", recorder.Code, cookies)
+	}
+}
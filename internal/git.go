@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type GitConfig struct {
+	URL          string
+	Ref          string
+	Path         string
+	Directory    string
+	PollInterval time.Duration
+}
+
+// GitDataSource reads the data file from a fixed ref of a git repository. New commits are fetched
+// periodically or on demand through Refresh, and the snapshot revision is the commit hash.
+type GitDataSource struct {
+	reloadTracker
+	changeTracker
+	config   GitConfig
+	refresh  sync.Mutex
+	snapshot atomic.Value
+	stop     chan struct{}
+	done     chan struct{}
+	// temporary is the clone directory created because none was configured, removed on Close
+	temporary string
+}
+
+func NewGitDataSource(config GitConfig) (*GitDataSource, error) {
+	if config.URL == "" {
+		return nil, errors.New("git data source: missing repository url")
+	}
+	if config.Ref == "" {
+		config.Ref = "HEAD"
+	}
+	if config.Path == "" {
+		config.Path = "data.yaml"
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = time.Minute
+	}
+
+	s := &GitDataSource{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if config.Directory == "" {
+		directory, err := os.MkdirTemp("", "teams-git-*")
+		if err != nil {
+			return nil, fmt.Errorf("git data source: %w", err)
+		}
+		s.config.Directory = directory
+		s.temporary = directory
+	}
+
+	if _, err := os.Stat(filepath.Join(s.config.Directory, "HEAD")); errors.Is(err, os.ErrNotExist) {
+		if _, err := s.git("init", "--bare", "--quiet", s.config.Directory); err != nil {
+			_ = s.removeTemporary()
+			return nil, fmt.Errorf("git data source: %w", err)
+		}
+	}
+
+	if err := s.Refresh(); err != nil {
+		_ = s.removeTemporary()
+		return nil, err
+	}
+
+	go s.monitor()
+	return s, nil
+}
+
+func (s *GitDataSource) monitor() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Refresh(); err != nil {
+				slog.Error("git data source refresh failed", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (s *GitDataSource) GetCurrent() DataSnapshot {
+	return s.snapshot.Load().(DataSnapshot)
+}
+
+func (s *GitDataSource) Refresh() error {
+	s.refresh.Lock()
+	defer s.refresh.Unlock()
+
+	if err := s.fetch(); err != nil {
+		s.failed(err)
+		return err
+	}
+
+	snapshot := s.GetCurrent()
+	s.succeeded(snapshot)
+	s.track(snapshot)
+	return nil
+}
+
+func (s *GitDataSource) fetch() error {
+	if _, err := s.git("-C", s.config.Directory, "fetch", "--quiet", "--force", s.config.URL, s.config.Ref); err != nil {
+		return fmt.Errorf("git fetch: %w", err)
+	}
+
+	output, err := s.git("-C", s.config.Directory, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return fmt.Errorf("git fetch: %w", err)
+	}
+	revision := strings.TrimSpace(string(output))
+
+	if current, ok := s.snapshot.Load().(DataSnapshot); ok && current.Revision == revision {
+		return nil
+	}
+
+	content, err := s.git("-C", s.config.Directory, "show", revision+":"+s.config.Path)
+	if err != nil {
+		return fmt.Errorf("git read %s at %s: %w", s.config.Path, revision, err)
+	}
+
+	snapshot, err := parseDataFile(s.config.Path, content)
+	if err != nil {
+		return fmt.Errorf("git data file at %s: %w", revision, err)
+	}
+
+	snapshot.Revision = revision
+	s.snapshot.Store(snapshot)
+	return nil
+}
+
+func (s *GitDataSource) Close() error {
+	close(s.stop)
+	<-s.done
+	return s.removeTemporary()
+}
+
+func (s *GitDataSource) removeTemporary() error {
+	if s.temporary == "" {
+		return nil
+	}
+	if err := os.RemoveAll(s.temporary); err != nil {
+		return fmt.Errorf("git data source: %w", err)
+	}
+	return nil
+}
+
+func (s *GitDataSource) git(args ...string) ([]byte, error) {
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.org"}, args...)
+	output, err := exec.Command("git", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return strings.TrimSpace(string(output))
+}
+
+func commitDataFile(t *testing.T, work string, content string) string {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(work, "data.yaml"), []byte(content), 0o644); err != nil {
+		t.Fatalf("write data file: %v", err)
+	}
+	runGit(t, work, "add", "data.yaml")
+	runGit(t, work, "commit", "--quiet", "-m", "update data")
+	runGit(t, work, "push", "--quiet", "origin", "HEAD:refs/heads/main")
+	return runGit(t, work, "rev-parse", "HEAD")
+}
+
+func TestGitDataSource(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	work := filepath.Join(root, "work")
+
+	runGit(t, root, "init", "--quiet", "--bare", remote)
+	runGit(t, root, "clone", "--quiet", remote, work)
+
+	first := commitDataFile(t, work, "teams:\n  team-1: [alice]\nusers:\n  - name: alice\n    key: "+key+"\n")
+
+	source, err := NewGitDataSource(GitConfig{
+		URL:          remote,
+		Ref:          "main",
+		Directory:    filepath.Join(root, "cache"),
+		PollInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	defer source.Close()
+
+	repository := NewSnapshotDataRepository(source)
+	if revision := repository.Snapshot().Revision; revision != first {
+		t.Fatalf("expected revision %s, got %s", first, revision)
+	}
+
+	t.Run("refresh picks up new commit", func(t *testing.T) {
+		second := commitDataFile(t, work, "teams:\n  team-1: [alice, bob]\nusers:\n  - name: alice\n    key: "+key+"\n  - name: bob\n    key: "+key+"\n")
+
+		if err := source.Refresh(); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+
+		snapshot := repository.Snapshot()
+		if snapshot.Revision != second {
+			t.Errorf("expected revision %s, got %s", second, snapshot.Revision)
+		}
+		if members := snapshot.Teams["team-1"]; !slices.Equal(members, []string{"alice", "bob"}) {
+			t.Errorf("expected updated members, got %v", members)
+		}
+	})
+
+	t.Run("invalid commit keeps last snapshot", func(t *testing.T) {
+		before := repository.Snapshot().Revision
+		commitDataFile(t, work, "teams:\n  team-1: [carol]\nusers: []\n")
+
+		if err := source.Refresh(); err == nil {
+			t.Fatal("expected refresh to fail")
+		}
+		if revision := repository.Snapshot().Revision; revision != before {
+			t.Errorf("expected revision %s to be kept, got %s", before, revision)
+		}
+	})
+}
+
+func TestGitDataSourceRemovesTemporaryClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	root := t.TempDir()
+	remote := filepath.Join(root, "remote.git")
+	work := filepath.Join(root, "work")
+
+	runGit(t, root, "init", "--quiet", "--bare", remote)
+	runGit(t, root, "clone", "--quiet", remote, work)
+	commitDataFile(t, work, "teams:\n  team-1: [alice]\nusers:\n  - name: alice\n    key: "+base64.StdEncoding.EncodeToString(pub)+"\n")
+
+	source, err := NewGitDataSource(GitConfig{URL: remote, Ref: "main", PollInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatalf("close source: %v", err)
+	}
+	if _, err := os.Stat(source.config.Directory); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the temporary clone to be removed, got %v", err)
+	}
+}
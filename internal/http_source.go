@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type HTTPConfig struct {
+	URL        string
+	Headers    map[string]string
+	Interval   time.Duration
+	MaxBackoff time.Duration
+	Timeout    time.Duration
+	Client     *http.Client
+}
+
+// HTTPDataSource polls a data file from a URL. Unchanged documents are detected with conditional
+// requests, and failed polls are retried with exponential backoff while the last good snapshot is kept.
+type HTTPDataSource struct {
+	reloadTracker
+	changeTracker
+	config       HTTPConfig
+	client       *http.Client
+	refresh      sync.Mutex
+	etag         string
+	lastModified string
+	snapshot     atomic.Value
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+func NewHTTPDataSource(config HTTPConfig) (*HTTPDataSource, error) {
+	if config.URL == "" {
+		return nil, errors.New("http data source: missing url")
+	}
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	if config.MaxBackoff < config.Interval {
+		config.MaxBackoff = 10 * config.Interval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	s := &HTTPDataSource{
+		config: config,
+		client: client,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	if err := s.Refresh(); err != nil {
+		return nil, err
+	}
+
+	go s.monitor()
+	return s, nil
+}
+
+func (s *HTTPDataSource) monitor() {
+	defer close(s.done)
+
+	failures := 0
+	timer := time.NewTimer(s.config.Interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-timer.C:
+			if err := s.Refresh(); err != nil {
+				failures++
+				slog.Error("http data source refresh failed", slog.Any("error", err), slog.Int("failures", failures))
+			} else {
+				failures = 0
+			}
+			timer.Reset(backoff(s.config.Interval, s.config.MaxBackoff, failures))
+		}
+	}
+}
+
+func backoff(interval time.Duration, limit time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < limit; i++ {
+		delay *= 2
+	}
+	return min(delay, limit)
+}
+
+func (s *HTTPDataSource) GetCurrent() DataSnapshot {
+	return s.snapshot.Load().(DataSnapshot)
+}
+
+func (s *HTTPDataSource) Refresh() error {
+	s.refresh.Lock()
+	defer s.refresh.Unlock()
+
+	if err := s.fetch(); err != nil {
+		s.failed(err)
+		return err
+	}
+
+	snapshot := s.GetCurrent()
+	s.succeeded(snapshot)
+	s.track(snapshot)
+	return nil
+}
+
+func (s *HTTPDataSource) fetch() error {
+	request, err := http.NewRequest(http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return fmt.Errorf("http fetch: %w", err)
+	}
+	for name, value := range s.config.Headers {
+		request.Header.Set(name, value)
+	}
+	if s.etag != "" {
+		request.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		request.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("http fetch: %w", err)
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil
+	default:
+		return fmt.Errorf("http fetch: unsuccessful status code %d", response.StatusCode)
+	}
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("http fetch: %w", err)
+	}
+
+	snapshot, err := parseDataFile(request.URL.Path, content)
+	if err != nil {
+		return fmt.Errorf("http data file: %w", err)
+	}
+
+	snapshot.Revision = strings.Trim(response.Header.Get("ETag"), `"`)
+	if snapshot.Revision == "" {
+		sum := sha256.Sum256(content)
+		snapshot.Revision = hex.EncodeToString(sum[:])
+	}
+
+	s.etag = response.Header.Get("ETag")
+	s.lastModified = response.Header.Get("Last-Modified")
+	s.snapshot.Store(snapshot)
+	return nil
+}
+
+func (s *HTTPDataSource) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type documentServer struct {
+	mutex       sync.Mutex
+	etag        string
+	content     string
+	status      int
+	conditional int
+}
+
+func (d *documentServer) set(etag string, content string, status int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.etag, d.content, d.status = etag, content, status
+}
+
+func (d *documentServer) conditionalRequests() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.conditional
+}
+
+func (d *documentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.status != http.StatusOK {
+		w.WriteHeader(d.status)
+		return
+	}
+	if r.Header.Get("If-None-Match") == d.etag {
+		d.conditional++
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("ETag", d.etag)
+	_, _ = w.Write([]byte(d.content))
+}
+
+func TestHTTPDataSource(t *testing.T) {
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	key := base64.StdEncoding.EncodeToString(pub)
+
+	document := &documentServer{}
+	document.set(`"v1"`, "teams:\n  team-1: [alice]\nusers:\n  - name: alice\n    key: "+key+"\n", http.StatusOK)
+
+	server := httptest.NewServer(document)
+	defer server.Close()
+
+	source, err := NewHTTPDataSource(HTTPConfig{URL: server.URL, Interval: time.Hour})
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	defer source.Close()
+
+	repository := NewSnapshotDataRepository(source)
+	if revision := repository.Snapshot().Revision; revision != "v1" {
+		t.Fatalf("expected revision v1, got %s", revision)
+	}
+
+	t.Run("unchanged document is not transferred again", func(t *testing.T) {
+		if err := source.Refresh(); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+		if conditional := document.conditionalRequests(); conditional != 1 {
+			t.Errorf("expected one not modified response, got %d", conditional)
+		}
+	})
+
+	t.Run("changed document replaces snapshot", func(t *testing.T) {
+		document.set(`"v2"`, "teams:\n  team-1: [alice, bob]\nusers:\n  - name: alice\n    key: "+key+"\n  - name: bob\n    key: "+key+"\n", http.StatusOK)
+		if err := source.Refresh(); err != nil {
+			t.Fatalf("refresh: %v", err)
+		}
+
+		members, _ := repository.GetTeamMembers("team-1")
+		if !slices.Equal(members, []string{"alice", "bob"}) {
+			t.Errorf("expected updated members, got %v", members)
+		}
+	})
+
+	t.Run("failures keep last good snapshot", func(t *testing.T) {
+		document.set(`"v3"`, "", http.StatusInternalServerError)
+		if err := source.Refresh(); err == nil {
+			t.Error("expected server error to fail refresh")
+		}
+
+		document.set(`"v4"`, "teams:\n  team-1: [carol]\n", http.StatusOK)
+		if err := source.Refresh(); err == nil {
+			t.Error("expected invalid document to fail refresh")
+		}
+
+		if revision := repository.Snapshot().Revision; revision != "v2" {
+			t.Errorf("expected revision v2 to be kept, got %s", revision)
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for failures, delay := range expected {
+		if actual := backoff(time.Second, 10*time.Second, failures); actual != delay {
+			t.Errorf("%d failures: expected %s, got %s", failures, delay, actual)
+		}
+	}
+}
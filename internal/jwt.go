@@ -1,17 +1,23 @@
 package internal
 
 import (
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
 	"time"
 )
 
+const accessTokenLifetime = time.Hour
+
 type JwtHelperConfig struct {
 	Issuer   string
 	Audience string
 	Leeway   time.Duration
 	Secret   []byte
+	// PrivateKey signs tokens with EdDSA instead of the secret, so services verifying them only
+	// need the public key.
+	PrivateKey ed25519.PrivateKey
 }
 
 type JwtHelper struct {
@@ -20,6 +26,11 @@ type JwtHelper struct {
 }
 
 func NewJwtHelper(config JwtHelperConfig) *JwtHelper {
+	method := jwt.SigningMethodHS512.Name
+	if config.PrivateKey != nil {
+		method = jwt.SigningMethodEdDSA.Alg()
+	}
+
 	return &JwtHelper{
 		config: config,
 		validationOptions: []jwt.ParserOption{
@@ -30,22 +41,43 @@ func NewJwtHelper(config JwtHelperConfig) *JwtHelper {
 			jwt.WithIssuer(config.Issuer),
 			jwt.WithLeeway(config.Leeway),
 
-			jwt.WithValidMethods([]string{jwt.SigningMethodHS512.Name}),
+			jwt.WithValidMethods([]string{method}),
 		},
 	}
 }
 
-func (j *JwtHelper) Create(username string, now time.Time) (string, error) {
-	claims := jwt.RegisteredClaims{
-		Subject:   username,
-		Issuer:    j.config.Issuer,
-		Audience:  []string{j.config.Audience},
-		ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
-		NotBefore: jwt.NewNumericDate(now),
-		IssuedAt:  jwt.NewNumericDate(now),
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// Teams are the teams of the user at login, so services can authorize without asking the server.
+	Teams []string `json:"teams,omitempty"`
+}
+
+func (c Claims) ParseRoles() ([]Role, error) {
+	return ParseRoles(c.Roles)
+}
+
+func (j *JwtHelper) Create(username string, roles []Role, teams []string, now time.Time) (string, error) {
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   username,
+			Issuer:    j.config.Issuer,
+			Audience:  []string{j.config.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenLifetime)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		Roles: formatRoles(roles),
+		Teams: teams,
 	}
 
-	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS512, &claims).SignedString(j.config.Secret)
+	var signed string
+	var err error
+	if j.config.PrivateKey != nil {
+		signed, err = jwt.NewWithClaims(jwt.SigningMethodEdDSA, &claims).SignedString(j.config.PrivateKey)
+	} else {
+		signed, err = jwt.NewWithClaims(jwt.SigningMethodHS512, &claims).SignedString(j.config.Secret)
+	}
 	if err != nil {
 		err = fmt.Errorf("access token creation: %w", err)
 		return "", err
@@ -54,20 +86,23 @@ func (j *JwtHelper) Create(username string, now time.Time) (string, error) {
 	return signed, nil
 }
 
-func (j *JwtHelper) Validate(accessToken string) (jwt.RegisteredClaims, error) {
-	claims := jwt.RegisteredClaims{}
+func (j *JwtHelper) Validate(accessToken string) (Claims, error) {
+	claims := Claims{}
 
 	token, err := jwt.ParseWithClaims(accessToken, &claims, j.resolveKey, j.validationOptions...)
 	if err != nil {
-		return jwt.RegisteredClaims{}, fmt.Errorf("validate access token: %w", err)
+		return Claims{}, fmt.Errorf("validate access token: %w", err)
 	}
 	if !token.Valid {
-		return jwt.RegisteredClaims{}, errors.New("validate access token: invalid token")
+		return Claims{}, errors.New("validate access token: invalid token")
 	}
 
 	return claims, nil
 }
 
 func (j *JwtHelper) resolveKey(_ *jwt.Token) (interface{}, error) {
+	if j.config.PrivateKey != nil {
+		return j.config.PrivateKey.Public(), nil
+	}
 	return j.config.Secret, nil
 }
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/go-ldap/ldap/v3"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type LDAPConfig struct {
+	URL             string
+	BindDN          string
+	BindPassword    string
+	RefreshInterval time.Duration
+	PageSize        uint32
+
+	UserBaseDN        string
+	UserFilter        string
+	UserNameAttribute string
+	UserKeyAttribute  string
+
+	GroupBaseDN          string
+	GroupFilter          string
+	GroupNameAttribute   string
+	GroupMemberAttribute string
+}
+
+func (c *LDAPConfig) applyDefaults() {
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = 5 * time.Minute
+	}
+	if c.PageSize == 0 {
+		c.PageSize = 500
+	}
+	if c.UserFilter == "" {
+		c.UserFilter = "(objectClass=inetOrgPerson)"
+	}
+	if c.UserNameAttribute == "" {
+		c.UserNameAttribute = "uid"
+	}
+	if c.GroupFilter == "" {
+		c.GroupFilter = "(objectClass=groupOfNames)"
+	}
+	if c.GroupNameAttribute == "" {
+		c.GroupNameAttribute = "cn"
+	}
+	if c.GroupMemberAttribute == "" {
+		c.GroupMemberAttribute = "member"
+	}
+}
+
+// LDAPDataSource periodically reads users and groups from a directory into a snapshot. Users without
+// a valid key are skipped, and group members are resolved either by their DN or by their username.
+type LDAPDataSource struct {
+	reloadTracker
+	changeTracker
+	config   LDAPConfig
+	snapshot atomic.Value
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewLDAPDataSource(config LDAPConfig) (*LDAPDataSource, error) {
+	config.applyDefaults()
+	if config.URL == "" || config.UserBaseDN == "" || config.GroupBaseDN == "" || config.UserKeyAttribute == "" {
+		return nil, errors.New("ldap data source: url, user base dn, group base dn and user key attribute are required")
+	}
+
+	s := &LDAPDataSource{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	snapshot, err := s.fetchSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	s.snapshot.Store(snapshot)
+	s.succeeded(snapshot)
+	s.track(snapshot)
+	go s.monitor()
+
+	return s, nil
+}
+
+func (s *LDAPDataSource) monitor() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			updatedSnapshot, err := s.fetchSnapshot()
+			if err != nil {
+				s.failed(err)
+				slog.Error("ldap data source refresh failed", slog.Any("error", err))
+			} else {
+				s.snapshot.Store(updatedSnapshot)
+				s.succeeded(updatedSnapshot)
+				s.track(updatedSnapshot)
+			}
+		}
+	}
+}
+
+func (s *LDAPDataSource) GetCurrent() DataSnapshot {
+	return s.snapshot.Load().(DataSnapshot)
+}
+
+func (s *LDAPDataSource) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+func (s *LDAPDataSource) fetchSnapshot() (DataSnapshot, error) {
+	conn, err := ldap.DialURL(s.config.URL)
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("ldap fetch: %w", err)
+	}
+	defer conn.Close()
+
+	if s.config.BindDN != "" {
+		if err := conn.Bind(s.config.BindDN, s.config.BindPassword); err != nil {
+			return DataSnapshot{}, fmt.Errorf("ldap bind: %w", err)
+		}
+	}
+
+	userEntries, err := s.search(conn, s.config.UserBaseDN, s.config.UserFilter, s.config.UserNameAttribute, s.config.UserKeyAttribute)
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("ldap search users: %w", err)
+	}
+
+	groupEntries, err := s.search(conn, s.config.GroupBaseDN, s.config.GroupFilter, s.config.GroupNameAttribute, s.config.GroupMemberAttribute)
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("ldap search groups: %w", err)
+	}
+
+	snapshot := DataSnapshot{
+		Users: make(map[string]ed25519.PublicKey, len(userEntries)),
+		Roles: make(map[string][]Role),
+		Teams: make(map[string][]string, len(groupEntries)),
+	}
+
+	usernamesByDN := make(map[string]string, len(userEntries))
+	for _, entry := range userEntries {
+		username := entry.GetAttributeValue(s.config.UserNameAttribute)
+		if username == "" {
+			continue
+		}
+
+		key, err := decodeLDAPKey(entry.GetRawAttributeValue(s.config.UserKeyAttribute))
+		if err != nil {
+			slog.Warn("skipping ldap user with invalid key", slog.String("dn", entry.DN), slog.Any("error", err))
+			continue
+		}
+
+		snapshot.Users[username] = key
+		usernamesByDN[normalizeDN(entry.DN)] = username
+	}
+
+	for _, entry := range groupEntries {
+		team := entry.GetAttributeValue(s.config.GroupNameAttribute)
+		if team == "" {
+			continue
+		}
+
+		members := make([]string, 0)
+		for _, value := range entry.GetAttributeValues(s.config.GroupMemberAttribute) {
+			username, ok := usernamesByDN[normalizeDN(value)]
+			if !ok {
+				username = value
+			}
+			if _, ok := snapshot.Users[username]; ok {
+				members = append(members, username)
+			}
+		}
+		snapshot.Teams[team] = members
+	}
+
+	return snapshot, nil
+}
+
+func (s *LDAPDataSource) search(conn *ldap.Conn, baseDN string, filter string, attributes ...string) ([]*ldap.Entry, error) {
+	request := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attributes,
+		nil,
+	)
+
+	result, err := conn.SearchWithPaging(request, s.config.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// decodeLDAPKey accepts the key either as raw bytes or base64 encoded.
+func decodeLDAPKey(value []byte) (ed25519.PublicKey, error) {
+	if len(value) == 0 {
+		return nil, errors.New("missing public key")
+	}
+	if len(value) == ed25519.PublicKeySize {
+		return value, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(string(value))
+	if err != nil {
+		return nil, fmt.Errorf("decode public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key has %d bytes, expected %d", len(key), ed25519.PublicKeySize)
+	}
+	return key, nil
+}
+
+func normalizeDN(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(dn))
+	}
+
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attributes := make([]string, 0, len(rdn.Attributes))
+		for _, attribute := range rdn.Attributes {
+			attributes = append(attributes, strings.ToLower(attribute.Type)+"="+strings.ToLower(attribute.Value))
+		}
+		rdns = append(rdns, strings.Join(attributes, "+"))
+	}
+	return strings.Join(rdns, ",")
+}
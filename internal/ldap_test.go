@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	ldapBindRequest      = 0
+	ldapBindResponse     = 1
+	ldapUnbindRequest    = 2
+	ldapSearchRequest    = 3
+	ldapSearchResultItem = 4
+	ldapSearchResultDone = 5
+
+	ldapResultSuccess            = 0
+	ldapResultInvalidCredentials = 49
+)
+
+type ldapEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// ldapStandIn is a minimal in-process directory. It answers simple binds and returns every entry
+// below the requested base DN, ignoring the search filter.
+type ldapStandIn struct {
+	listener net.Listener
+	bindDN   string
+	password string
+
+	mutex   sync.Mutex
+	entries []ldapEntry
+}
+
+func startLDAPStandIn(t *testing.T, bindDN string, password string, entries []ldapEntry) *ldapStandIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	s := &ldapStandIn{listener: listener, bindDN: bindDN, password: password, entries: entries}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *ldapStandIn) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *ldapStandIn) SetEntries(entries []ldapEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = entries
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+
+		id := packet.Children[0].Value.(int64)
+		operation := packet.Children[1]
+
+		switch operation.Tag {
+		case ldapBindRequest:
+			name := operation.Children[1].Data.String()
+			password := operation.Children[2].Data.String()
+
+			code := ldapResultSuccess
+			if name != s.bindDN || password != s.password {
+				code = ldapResultInvalidCredentials
+			}
+			s.write(conn, id, ldapResult(ldapBindResponse, code))
+		case ldapSearchRequest:
+			baseDN := strings.ToLower(operation.Children[0].Data.String())
+
+			s.mutex.Lock()
+			entries := slices.Clone(s.entries)
+			s.mutex.Unlock()
+
+			for _, entry := range entries {
+				if strings.HasSuffix(strings.ToLower(entry.dn), baseDN) {
+					s.write(conn, id, ldapSearchEntry(entry))
+				}
+			}
+			s.write(conn, id, ldapResult(ldapSearchResultDone, ldapResultSuccess))
+		case ldapUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapStandIn) write(conn io.Writer, id int64, operation *ber.Packet) {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(operation)
+	_, _ = conn.Write(envelope.Bytes())
+}
+
+func ldapResult(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return result
+}
+
+func ldapSearchEntry(entry ldapEntry) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldapSearchResultItem, nil, "")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+
+	return result
+}
+
+func TestLDAPDataSource(t *testing.T) {
+	alicePub, _, _ := ed25519.GenerateKey(rand.Reader)
+	bobPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	users := []ldapEntry{
+		{dn: "uid=alice,ou=people,dc=example,dc=org", attributes: map[string][]string{
+			"uid": {"alice"}, "teamsPublicKey": {base64.StdEncoding.EncodeToString(alicePub)},
+		}},
+		{dn: "uid=bob,ou=people,dc=example,dc=org", attributes: map[string][]string{
+			"uid": {"bob"}, "teamsPublicKey": {string(bobPub)},
+		}},
+		{dn: "uid=carol,ou=people,dc=example,dc=org", attributes: map[string][]string{
+			"uid": {"carol"},
+		}},
+	}
+	team1 := ldapEntry{dn: "cn=team-1,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+		"cn":     {"team-1"},
+		"member": {"UID=Alice, ou=People,dc=example,dc=org", "uid=carol,ou=people,dc=example,dc=org", "bob"},
+	}}
+
+	standIn := startLDAPStandIn(t, "cn=reader,dc=example,dc=org", "secret", append(slices.Clone(users), team1))
+
+	config := LDAPConfig{
+		URL:              standIn.URL(),
+		BindDN:           "cn=reader,dc=example,dc=org",
+		BindPassword:     "secret",
+		RefreshInterval:  50 * time.Millisecond,
+		UserBaseDN:       "ou=people,dc=example,dc=org",
+		UserKeyAttribute: "teamsPublicKey",
+		GroupBaseDN:      "ou=groups,dc=example,dc=org",
+	}
+
+	source, err := NewLDAPDataSource(config)
+	if err != nil {
+		t.Fatalf("create source: %v", err)
+	}
+	defer source.Close()
+
+	repository := NewSnapshotDataRepository(source)
+
+	t.Run("users with keys", func(t *testing.T) {
+		key, found := repository.GetUserPublicKey("alice")
+		if !found || !key.Equal(alicePub) {
+			t.Error("expected base64 encoded key of alice")
+		}
+		key, found = repository.GetUserPublicKey("bob")
+		if !found || !key.Equal(bobPub) {
+			t.Error("expected raw key of bob")
+		}
+		if repository.UserExists("carol") {
+			t.Error("expected user without key to be skipped")
+		}
+	})
+
+	t.Run("groups as teams", func(t *testing.T) {
+		members, found := repository.GetTeamMembers("team-1")
+		if !found || !slices.Equal(members, []string{"alice", "bob"}) {
+			t.Errorf("expected members resolved by dn and name, got %v", members)
+		}
+	})
+
+	t.Run("periodic refresh", func(t *testing.T) {
+		team2 := ldapEntry{dn: "cn=team-2,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+			"cn": {"team-2"}, "member": {"uid=bob,ou=people,dc=example,dc=org"},
+		}}
+		standIn.SetEntries(append(slices.Clone(users), team1, team2))
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			if members, found := repository.GetTeamMembers("team-2"); found && slices.Equal(members, []string{"bob"}) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("expected refresh to pick up new group")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		invalid := config
+		invalid.BindPassword = "wrong"
+
+		_, err := NewLDAPDataSource(invalid)
+		var ldapErr *ldap.Error
+		if !errors.As(err, &ldapErr) || ldapErr.ResultCode != ldap.LDAPResultInvalidCredentials {
+			t.Errorf("expected invalid credentials error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+type RateLimit struct {
+	// Burst is the number of attempts that are allowed at once.
+	Burst int
+	// Refill is the time after which one more attempt is allowed.
+	Refill time.Duration
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Burst > 0 && l.Refill > 0
+}
+
+type LoginLimiterConfig struct {
+	IP   RateLimit
+	User RateLimit
+	// MaxFailures failed logins in a row lock the user for LockoutDuration. Failures further apart
+	// than LockoutDuration are not counted as in a row. Lockout is disabled if MaxFailures is 0.
+	MaxFailures     int
+	LockoutDuration time.Duration
+}
+
+// LoginCounters holds the state of a LoginLimiter. Keys are prefixed with ip: or user:.
+type LoginCounters interface {
+	// Take removes a token from the bucket of key and reports whether one was available. If not,
+	// it returns the time until the next token is available.
+	Take(key string, limit RateLimit, now time.Time) (bool, time.Duration)
+	// Fail counts a failure of key and returns the number of failures since the last Reset, not
+	// counting failures that are older than window.
+	Fail(key string, window time.Duration, now time.Time) int
+	Reset(key string)
+	Lock(key string, until time.Time)
+	// LockedFor returns the remaining time of the lockout of key, which is 0 if it is not locked.
+	LockedFor(key string, now time.Time) time.Duration
+}
+
+// LoginLimiter limits login attempts per client address and username, and locks users after
+// repeated failures. A nil *LoginLimiter allows every attempt.
+type LoginLimiter struct {
+	config   LoginLimiterConfig
+	counters LoginCounters
+}
+
+func NewLoginLimiter(config LoginLimiterConfig, counters LoginCounters) *LoginLimiter {
+	if counters == nil {
+		counters = NewMemoryLoginCounters()
+	}
+	return &LoginLimiter{config: config, counters: counters}
+}
+
+// Allow reports whether a login of username from ip may be attempted. If not, it returns the
+// audit reason and the time after which the client may try again.
+func (l *LoginLimiter) Allow(ip string, username string, now time.Time) (bool, string, time.Duration) {
+	if l == nil {
+		return true, "", 0
+	}
+
+	if l.config.IP.enabled() {
+		if allowed, wait := l.counters.Take("ip:"+ip, l.config.IP, now); !allowed {
+			return false, AuditReasonRateLimited, wait
+		}
+	}
+	if l.config.MaxFailures > 0 {
+		if wait := l.counters.LockedFor("user:"+username, now); wait > 0 {
+			return false, AuditReasonLockedOut, wait
+		}
+	}
+	if l.config.User.enabled() {
+		if allowed, wait := l.counters.Take("user:"+username, l.config.User, now); !allowed {
+			return false, AuditReasonRateLimited, wait
+		}
+	}
+	return true, "", 0
+}
+
+func (l *LoginLimiter) Failed(username string, now time.Time) {
+	if l == nil || l.config.MaxFailures <= 0 {
+		return
+	}
+
+	key := "user:" + username
+	if l.counters.Fail(key, l.config.LockoutDuration, now) >= l.config.MaxFailures {
+		l.counters.Lock(key, now.Add(l.config.LockoutDuration))
+		l.counters.Reset(key)
+		slog.Warn("locking user after failed logins", slog.String("user", username), slog.Duration("duration", l.config.LockoutDuration))
+	}
+}
+
+func (l *LoginLimiter) Succeeded(username string) {
+	if l == nil || l.config.MaxFailures <= 0 {
+		return
+	}
+	l.counters.Reset("user:" + username)
+}
+
+// MemoryLoginCounters keeps the counters of a single server in memory. Entries that no longer
+// affect any decision are dropped once a minute.
+type MemoryLoginCounters struct {
+	mutex     sync.Mutex
+	buckets   map[string]*tokenBucket
+	failures  map[string]*failureCount
+	locks     map[string]time.Time
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket is refilled completely, after which it can be forgotten
+	full time.Time
+}
+
+type failureCount struct {
+	count   int
+	expires time.Time
+}
+
+func NewMemoryLoginCounters() *MemoryLoginCounters {
+	return &MemoryLoginCounters{
+		buckets:  map[string]*tokenBucket{},
+		failures: map[string]*failureCount{},
+		locks:    map[string]time.Time{},
+	}
+}
+
+func (m *MemoryLoginCounters) Take(key string, limit RateLimit, now time.Time) (bool, time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sweep(now)
+
+	burst := float64(limit.Burst)
+	bucket, found := m.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		m.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+float64(now.Sub(bucket.updated))/float64(limit.Refill))
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) * float64(limit.Refill))
+	}
+
+	bucket.tokens--
+	bucket.full = now.Add(time.Duration((burst - bucket.tokens) * float64(limit.Refill)))
+	return true, 0
+}
+
+func (m *MemoryLoginCounters) Fail(key string, window time.Duration, now time.Time) int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sweep(now)
+
+	failure, found := m.failures[key]
+	if !found || now.After(failure.expires) {
+		failure = &failureCount{}
+		m.failures[key] = failure
+	}
+	failure.count++
+	failure.expires = now.Add(window)
+	return failure.count
+}
+
+func (m *MemoryLoginCounters) Reset(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.failures, key)
+}
+
+func (m *MemoryLoginCounters) Lock(key string, until time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.locks[key] = until
+}
+
+func (m *MemoryLoginCounters) LockedFor(key string, now time.Time) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	until, found := m.locks[key]
+	if !found || !until.After(now) {
+		return 0
+	}
+	return until.Sub(now)
+}
+
+func (m *MemoryLoginCounters) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+
+	for key, bucket := range m.buckets {
+		if !bucket.full.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+	for key, failure := range m.failures {
+		if now.After(failure.expires) {
+			delete(m.failures, key)
+		}
+	}
+	for key, until := range m.locks {
+		if !until.After(now) {
+			delete(m.locks, key)
+		}
+	}
+}
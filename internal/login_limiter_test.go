@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginLimiterRefillsBuckets(t *testing.T) {
+	limiter := NewLoginLimiter(LoginLimiterConfig{IP: RateLimit{Burst: 2, Refill: 10 * time.Second}}, nil)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := limiter.Allow("10.0.0.1", "alice", now); !allowed {
+			t.Fatalf("expected attempt %d to be within the burst", i)
+		}
+	}
+
+	allowed, reason, wait := limiter.Allow("10.0.0.1", "alice", now)
+	if allowed || reason != AuditReasonRateLimited || wait != 10*time.Second {
+		t.Errorf("expected attempt beyond the burst to wait 10s, got %v, %q, %s", allowed, reason, wait)
+	}
+	if allowed, _, _ := limiter.Allow("10.0.0.2", "alice", now); !allowed {
+		t.Error("expected other addresses to be limited separately")
+	}
+	if allowed, _, _ := limiter.Allow("10.0.0.1", "alice", now.Add(10*time.Second)); !allowed {
+		t.Error("expected a token after the refill")
+	}
+}
+
+func TestLoginLimiterLocksOutAfterFailures(t *testing.T) {
+	limiter := NewLoginLimiter(LoginLimiterConfig{MaxFailures: 3, LockoutDuration: time.Minute}, nil)
+	now := time.Now()
+
+	limiter.Failed("alice", now)
+	limiter.Failed("alice", now)
+	limiter.Succeeded("alice")
+	limiter.Failed("alice", now)
+	limiter.Failed("alice", now)
+	if allowed, _, _ := limiter.Allow("10.0.0.1", "alice", now); !allowed {
+		t.Fatal("expected a successful login to reset the failures")
+	}
+
+	limiter.Failed("alice", now)
+	allowed, reason, wait := limiter.Allow("10.0.0.1", "alice", now.Add(time.Second))
+	if allowed || reason != AuditReasonLockedOut || wait != 59*time.Second {
+		t.Errorf("expected alice to be locked out, got %v, %q, %s", allowed, reason, wait)
+	}
+	if allowed, _, _ := limiter.Allow("10.0.0.1", "alice", now.Add(time.Minute)); !allowed {
+		t.Error("expected lockout to end")
+	}
+}
+
+func TestServerLimitsLogins(t *testing.T) {
+	pub, priv := newTestKey(t)
+	_, other := newTestKey(t)
+
+	repository := NewSnapshotDataRepository(staticSnapshot{Users: map[string]ed25519.PublicKey{"alice": pub}})
+	limiter := NewLoginLimiter(LoginLimiterConfig{MaxFailures: 2, LockoutDuration: time.Minute}, nil)
+	server, _ := newTestServer(t, ServerConfig{LoginLimiter: limiter, UniformLoginErrors: true}, repository)
+
+	login := func(username string, key ed25519.PrivateKey) *httptest.ResponseRecorder {
+		now := time.Now()
+		body, _ := json.Marshal(api.LoginRequest{Username: username, Timestamp: now, Challenge: api.CreateChallenge(username, now, key)})
+		request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	if code := login("bob", priv).Code; code != http.StatusUnauthorized {
+		t.Errorf("expected unknown user to be answered like an invalid signature, got %d", code)
+	}
+
+	login("alice", other)
+	login("alice", other)
+	recorder := login("alice", priv)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected alice to be locked out for a minute, got %d with Retry-After %q", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+}
+
+func TestServerLimitsLoginsByConnectionAddress(t *testing.T) {
+	pub, _ := newTestKey(t)
+	repository := NewSnapshotDataRepository(staticSnapshot{Users: map[string]ed25519.PublicKey{"alice": pub}})
+	trustedProxies, _ := ParseTrustedProxies([]string{"10.0.0.1"})
+
+	for _, trusted := range []bool{false, true} {
+		config := ServerConfig{LoginLimiter: NewLoginLimiter(LoginLimiterConfig{IP: RateLimit{Burst: 2, Refill: time.Hour}}, nil)}
+		if trusted {
+			config.TrustedProxies = trustedProxies
+		}
+		server, _ := newTestServer(t, config, repository)
+
+		codes := make([]int, 0, 3)
+		for i := 0; i < 3; i++ {
+			request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader([]byte(`{"username":"alice"}`)))
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("X-Forwarded-For", fmt.Sprintf("1.2.3.%d", i))
+			request.RemoteAddr = "10.0.0.1:1234"
+			recorder := httptest.NewRecorder()
+			server.ServeHTTP(recorder, request)
+			codes = append(codes, recorder.Code)
+		}
+
+		// behind a trusted proxy, every forwarded address has its own bucket
+		if limited := codes[2] == http.StatusTooManyRequests; limited == trusted {
+			t.Errorf("trusted proxy %t: expected the third login to be limited only without trusted proxies, got %v", trusted, codes)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const metricsNamespace = "teams"
+
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	logins          *prometheus.CounterVec
+	tokensIssued    prometheus.Counter
+	verifications   *prometheus.CounterVec
+}
+
+// NewMetrics creates the server metrics. The reload counters, snapshot age and user and team
+// counts are read from the repository when it reports its status.
+func NewMetrics(repository DataRepository) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "logins_total",
+			Help:      "Login attempts by outcome and failure reason.",
+		}, []string{"outcome", "reason"}),
+		tokensIssued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokens_issued_total",
+			Help:      "Access tokens issued.",
+		}),
+		verifications: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "token_verifications_total",
+			Help:      "Token verifications by outcome and failure reason.",
+		}, []string{"outcome", "reason"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.logins,
+		m.tokensIssued,
+		m.verifications,
+	)
+
+	if reporter, ok := repository.(StatusReporter); ok {
+		m.registry.MustRegister(newStatusCollector(reporter))
+	}
+
+	return m
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				c.Error(err)
+			}
+
+			// the route template keeps the number of label values bounded
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			method := c.Request().Method
+			m.requests.WithLabelValues(method, route, strconv.Itoa(c.Response().Status)).Inc()
+			m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+			return nil
+		}
+	}
+}
+
+// observe counts the login and verify outcomes, which the server records as audit events.
+func (m *Metrics) observe(event api.AuditEvent) {
+	if m == nil {
+		return
+	}
+
+	switch event.Action {
+	case AuditLogin:
+		m.logins.WithLabelValues(event.Outcome, event.Reason).Inc()
+		if event.Outcome == AuditSuccess {
+			m.tokensIssued.Inc()
+		}
+	case AuditVerify:
+		m.verifications.WithLabelValues(event.Outcome, event.Reason).Inc()
+	}
+}
+
+type statusCollector struct {
+	reporter    StatusReporter
+	reloads     *prometheus.Desc
+	snapshotAge *prometheus.Desc
+	healthy     *prometheus.Desc
+	users       *prometheus.Desc
+	teams       *prometheus.Desc
+}
+
+func newStatusCollector(reporter StatusReporter) *statusCollector {
+	name := func(name string) string {
+		return prometheus.BuildFQName(metricsNamespace, "data", name)
+	}
+
+	return &statusCollector{
+		reporter:    reporter,
+		reloads:     prometheus.NewDesc(name("reloads_total"), "Reloads of the data by outcome.", []string{"outcome"}, nil),
+		snapshotAge: prometheus.NewDesc(name("snapshot_age_seconds"), "Seconds since the data was last loaded successfully.", nil, nil),
+		healthy:     prometheus.NewDesc(name("healthy"), "Whether the last reload of the data succeeded.", nil, nil),
+		users:       prometheus.NewDesc(name("users"), "Users in the current snapshot.", nil, nil),
+		teams:       prometheus.NewDesc(name("teams"), "Teams in the current snapshot.", nil, nil),
+	}
+}
+
+func (c *statusCollector) Describe(descriptions chan<- *prometheus.Desc) {
+	descriptions <- c.reloads
+	descriptions <- c.snapshotAge
+	descriptions <- c.healthy
+	descriptions <- c.users
+	descriptions <- c.teams
+}
+
+func (c *statusCollector) Collect(metrics chan<- prometheus.Metric) {
+	status := c.reporter.Status()
+
+	healthy := 0.0
+	if status.Healthy() {
+		healthy = 1
+	}
+
+	metrics <- prometheus.MustNewConstMetric(c.reloads, prometheus.CounterValue, float64(status.Successes), AuditSuccess)
+	metrics <- prometheus.MustNewConstMetric(c.reloads, prometheus.CounterValue, float64(status.Failures), AuditFailure)
+	metrics <- prometheus.MustNewConstMetric(c.healthy, prometheus.GaugeValue, healthy)
+	metrics <- prometheus.MustNewConstMetric(c.users, prometheus.GaugeValue, float64(status.Users))
+	metrics <- prometheus.MustNewConstMetric(c.teams, prometheus.GaugeValue, float64(status.Teams))
+	if !status.LastSuccess.IsZero() {
+		metrics <- prometheus.MustNewConstMetric(c.snapshotAge, prometheus.GaugeValue, time.Since(status.LastSuccess).Seconds())
+	}
+}
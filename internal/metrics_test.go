@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	repository := NewSnapshotDataRepository(staticSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": {1}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	})
+	metrics := NewMetrics(repository)
+	server, _ := newTestServer(t, ServerConfig{}, repository)
+	server.InitMetrics(metrics)
+
+	for _, target := range []string{"/teams/team-1", "/teams/team-2", "/verify?access_token=invalid"} {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+
+	for _, expected := range []string{
+		`teams_http_requests_total{code="200",method="GET",route="/teams/:id"} 1`,
+		`teams_http_requests_total{code="404",method="GET",route="/teams/:id"} 1`,
+		`teams_token_verifications_total{outcome="failure",reason="invalid_token"} 1`,
+		`teams_data_users 1`,
+		`teams_data_teams 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+}
+
+func TestMetricsReportSQLiteCounts(t *testing.T) {
+	repository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer repository.Close()
+
+	metrics := NewMetrics(repository)
+	err = repository.Import(DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": {1}, "bob": {2}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, expected := range []string{"teams_data_users 2", "teams_data_teams 1"} {
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type migration struct {
+	version int
+	name    string
+	script  string
+}
+
+func loadMigrations(migrations fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(migrations, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]migration, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		prefix, _, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version prefix", name)
+		}
+
+		script, err := fs.ReadFile(migrations, name)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, migration{version: version, name: name, script: string(script)})
+	}
+
+	slices.SortFunc(result, func(a, b migration) int { return a.version - b.version })
+	return result, nil
+}
+
+// migrate applies all scripts of migrations that are newer than the version recorded in schema_migrations.
+// Each script runs in its own transaction together with the version bump.
+func migrate(ctx context.Context, conn *sql.Conn, migrations fs.FS) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	var current int
+	err = conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	scripts, err := loadMigrations(migrations)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	for _, m := range scripts {
+		if m.version <= current {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("migrate %s: %w", m.name, err)
+		}
+		if _, err := tx.Exec(m.script); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate %s: %w", m.name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, m.version); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("migrate %s: %w", m.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate %s: %w", m.name, err)
+		}
+	}
+
+	return nil
+}
+
+// migrateDatabase runs migrate on a dedicated connection of db. If lock is given, it is executed on that
+// connection before and unlock after migrating, so concurrently starting servers serialize their migrations.
+func migrateDatabase(db *sql.DB, migrations fs.FS, lock string, unlock string) error {
+	ctx := context.Background()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+	defer conn.Close()
+
+	if lock != "" {
+		if _, err := conn.ExecContext(ctx, lock); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+		defer conn.ExecContext(ctx, unlock)
+	}
+
+	return migrate(ctx, conn, migrations)
+}
@@ -0,0 +1,40 @@
+CREATE TABLE users (
+    name TEXT PRIMARY KEY,
+    key  BYTEA NOT NULL
+);
+
+CREATE TABLE user_roles (
+    username TEXT NOT NULL REFERENCES users (name) ON DELETE CASCADE,
+    role     TEXT NOT NULL,
+    PRIMARY KEY (username, role)
+);
+
+CREATE TABLE teams (
+    name TEXT PRIMARY KEY
+);
+
+CREATE TABLE team_members (
+    id       BIGSERIAL PRIMARY KEY,
+    team     TEXT NOT NULL REFERENCES teams (name) ON DELETE CASCADE,
+    username TEXT NOT NULL REFERENCES users (name) ON DELETE CASCADE,
+    UNIQUE (team, username)
+);
+
+CREATE FUNCTION notify_teams_data_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('teams_data_changed', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON users
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_teams_data_changed();
+
+CREATE TRIGGER user_roles_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON user_roles
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_teams_data_changed();
+
+CREATE TRIGGER teams_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON teams
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_teams_data_changed();
+
+CREATE TRIGGER team_members_changed AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON team_members
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_teams_data_changed();
@@ -0,0 +1,21 @@
+CREATE TABLE users (
+    name TEXT PRIMARY KEY,
+    key  BLOB NOT NULL
+);
+
+CREATE TABLE user_roles (
+    username TEXT NOT NULL REFERENCES users (name) ON DELETE CASCADE,
+    role     TEXT NOT NULL,
+    PRIMARY KEY (username, role)
+);
+
+CREATE TABLE teams (
+    name TEXT PRIMARY KEY
+);
+
+CREATE TABLE team_members (
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    team     TEXT NOT NULL REFERENCES teams (name) ON DELETE CASCADE,
+    username TEXT NOT NULL REFERENCES users (name) ON DELETE CASCADE,
+    UNIQUE (team, username)
+);
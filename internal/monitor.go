@@ -2,110 +2,318 @@ package internal
 
 import (
 	"crypto/ed25519"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
-	"github.com/spf13/viper"
-	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type DataSnapshot struct {
-	Users map[string]ed25519.PublicKey
-	Teams map[string][]string
+	Revision string
+	Users    map[string]ed25519.PublicKey
+	Roles    map[string][]Role
+	Teams    map[string][]string
 }
 
+func (s DataSnapshot) Clone() DataSnapshot {
+	clone := DataSnapshot{
+		Revision: s.Revision,
+		Users:    make(map[string]ed25519.PublicKey, len(s.Users)),
+		Roles:    make(map[string][]Role, len(s.Roles)),
+		Teams:    make(map[string][]string, len(s.Teams)),
+	}
+	for name, key := range s.Users {
+		clone.Users[name] = slices.Clone(key)
+	}
+	for name, roles := range s.Roles {
+		clone.Roles[name] = slices.Clone(roles)
+	}
+	for team, members := range s.Teams {
+		clone.Teams[team] = slices.Clone(members)
+	}
+	return clone
+}
+
+type DataMonitorConfig struct {
+	Paths        []string
+	TrustedKeys  []ed25519.PublicKey
+	Debounce     time.Duration
+	PollInterval time.Duration
+}
+
+// DataMonitor keeps a snapshot of the data files and reloads it when they change. Changes are
+// detected by watching the directories that contain the files, which also covers atomic renames
+// and the symlink swaps Kubernetes performs for ConfigMaps. Bursts of events are debounced into
+// one reload. If the watch is lost, the files are polled until it can be established again.
 type DataMonitor struct {
-	reload   chan struct{}
-	stop     chan struct{}
-	snapshot atomic.Value
+	reloadTracker
+	changeTracker
+	config      DataMonitorConfig
+	directories []string
+	watcher     *fsnotify.Watcher
+	stop        chan struct{}
+	done        chan struct{}
+	write       sync.Mutex
+	snapshot    atomic.Value
 }
 
-func NewDataMonitor(path string) (*DataMonitor, error) {
-	v := viper.New()
-	v.SetConfigFile(path)
-	if err := v.ReadInConfig(); err != nil {
-		return nil, err
+var ErrReadOnlyData = errors.New("data files cannot be modified by the server")
+
+// ErrUnwatchableGlob is returned for patterns like teams/*/data.yaml, as only the directories
+// containing the files are watched and those are not known in advance.
+var ErrUnwatchableGlob = errors.New("only the file names of watched data paths may contain globs")
+
+func NewDataMonitor(config DataMonitorConfig) (*DataMonitor, error) {
+	if config.Debounce <= 0 {
+		config.Debounce = 100 * time.Millisecond
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 10 * time.Second
+	}
+
+	for _, pattern := range config.Paths {
+		if isGlob(filepath.Dir(pattern)) {
+			return nil, fmt.Errorf("%w: %s", ErrUnwatchableGlob, pattern)
+		}
 	}
 
 	m := &DataMonitor{
-		reload: make(chan struct{}),
+		config: config,
 		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
 	}
+	m.directories = m.watchedDirectories()
 
-	snapshot, err := createSnapshot(v)
+	snapshot, err := m.load()
 	if err != nil {
 		return nil, err
 	}
 
-	m.snapshot.Store(snapshot)
-	go m.monitor(v)
+	watcher, err := m.startWatching()
+	if err != nil {
+		return nil, err
+	}
+	m.watcher = watcher
 
-	v.OnConfigChange(func(event fsnotify.Event) {
-		m.reload <- struct{}{}
-	})
-	v.WatchConfig()
+	m.snapshot.Store(snapshot)
+	m.succeeded(snapshot)
+	m.track(snapshot)
+	go m.monitor()
 
 	return m, nil
 }
 
-func (m *DataMonitor) monitor(v *viper.Viper) {
+func (m *DataMonitor) watchedDirectories() []string {
+	directories := make([]string, 0, len(m.config.Paths))
+	for _, pattern := range m.config.Paths {
+		directory := filepath.Dir(pattern)
+		if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+			directory = pattern
+		}
+
+		directory = filepath.Clean(directory)
+		if !slices.Contains(directories, directory) {
+			directories = append(directories, directory)
+		}
+	}
+	return directories
+}
+
+func (m *DataMonitor) startWatching() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, directory := range m.directories {
+		if err := watcher.Add(directory); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+	return watcher, nil
+}
+
+func (m *DataMonitor) monitor() {
+	defer close(m.done)
+
+	debounce := time.NewTimer(m.config.Debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	poll := time.NewTicker(m.config.PollInterval)
+	defer poll.Stop()
+
+	watcher := m.watcher
+	defer func() {
+		if watcher != nil {
+			_ = watcher.Close()
+		}
+	}()
+
+	loseWatch := func(reason error) {
+		slog.Warn("data file watch lost, polling", slog.Duration("interval", m.config.PollInterval), slog.Any("error", reason))
+		_ = watcher.Close()
+		watcher = nil
+	}
+
 	for {
+		// a nil channel blocks forever, so events are only received while watching
+		var events chan fsnotify.Event
+		var errs chan error
+		if watcher != nil {
+			events, errs = watcher.Events, watcher.Errors
+		}
+
 		select {
 		case <-m.stop:
-			fmt.Println("stop")
 			return
-		case <-m.reload:
-			updatedSnapshot, err := createSnapshot(v)
-			if err != nil {
-				log.Println(err)
-			} else {
-				m.snapshot.Store(updatedSnapshot)
+		case event, ok := <-events:
+			if !ok {
+				loseWatch(errors.New("watcher closed"))
+				continue
+			}
+			if slices.Contains(m.directories, filepath.Clean(event.Name)) && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+				loseWatch(fmt.Errorf("directory %s was removed", event.Name))
+			}
+			debounce.Reset(m.config.Debounce)
+		case err, ok := <-errs:
+			if !ok {
+				loseWatch(errors.New("watcher closed"))
+				continue
+			}
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				debounce.Reset(m.config.Debounce)
+				continue
+			}
+			loseWatch(err)
+		case <-debounce.C:
+			m.reload()
+		case <-poll.C:
+			if watcher != nil {
+				continue
 			}
+			if restarted, err := m.startWatching(); err == nil {
+				slog.Info("data file watch established again")
+				watcher = restarted
+			}
+			m.reload()
 		}
 	}
 }
 
-func (m *DataMonitor) GetCurrent() DataSnapshot {
-	return m.snapshot.Load().(DataSnapshot)
-}
-
-func (m *DataMonitor) Close() error {
-	close(m.stop)
-	return nil
-}
+func (m *DataMonitor) reload() {
+	updatedSnapshot, err := m.load()
+	if err != nil {
+		m.failed(err)
+		slog.Error("reloading data files failed", slog.Any("error", err))
+		return
+	}
 
-func createSnapshot(v *viper.Viper) (DataSnapshot, error) {
-	type rawDataFileContent struct {
-		Teams map[string][]string `yaml:"teams"`
-		Users []struct {
-			Name string `yaml:"name"`
-			Key  string `yaml:"key"`
-		} `yaml:"users"`
+	if updatedSnapshot.Revision != m.GetCurrent().Revision {
+		m.snapshot.Store(updatedSnapshot)
 	}
+	m.succeeded(updatedSnapshot)
+	m.track(updatedSnapshot)
+}
 
-	content := rawDataFileContent{}
-	if err := v.Unmarshal(&content); err != nil {
+func (m *DataMonitor) load() (DataSnapshot, error) {
+	paths, err := ResolveDataFiles(m.config.Paths)
+	if err != nil {
 		return DataSnapshot{}, err
 	}
 
-	users := make(map[string]ed25519.PublicKey, len(content.Users))
-	for _, u := range content.Users {
-		key, err := base64.StdEncoding.DecodeString(u.Key)
+	hash := sha256.New()
+	files := make([]dataFile, 0, len(paths))
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
 		if err != nil {
 			return DataSnapshot{}, err
 		}
-		users[u.Name] = key
-	}
+		hash.Write([]byte(path))
+		hash.Write(content)
 
-	for _, members := range content.Teams {
-		for _, m := range members {
-			if _, ok := users[m]; !ok {
-				return DataSnapshot{}, errors.New("user in team does not exist")
+		if len(m.config.TrustedKeys) > 0 {
+			signature, err := os.ReadFile(path + SignatureSuffix)
+			if err != nil {
+				return DataSnapshot{}, fmt.Errorf("read data file signature: %w", err)
+			}
+			if err := VerifyDataSignature(content, signature, m.config.TrustedKeys); err != nil {
+				return DataSnapshot{}, fmt.Errorf("%s: %w", path, err)
 			}
 		}
+
+		files = append(files, decodeDataFile(path, content))
+	}
+
+	snapshot, err := mergeDataFiles(files, false)
+	if err != nil {
+		return DataSnapshot{}, err
+	}
+
+	snapshot.Revision = hex.EncodeToString(hash.Sum(nil))
+	return snapshot, nil
+}
+
+// Writable reports whether Update can write changes back. This is only possible for a single
+// unsigned data file, as the server can neither sign nor decide which of several files to change.
+func (m *DataMonitor) Writable() bool {
+	if len(m.config.TrustedKeys) > 0 || len(m.config.Paths) != 1 {
+		return false
+	}
+
+	path := m.config.Paths[0]
+	info, err := os.Stat(path)
+	return !isGlob(path) && err == nil && info.Mode().IsRegular()
+}
+
+func (m *DataMonitor) GetCurrent() DataSnapshot {
+	return m.snapshot.Load().(DataSnapshot)
+}
+
+func (m *DataMonitor) Update(modify func(snapshot *DataSnapshot) error) error {
+	if !m.Writable() {
+		return ErrReadOnlyData
+	}
+
+	m.write.Lock()
+	defer m.write.Unlock()
+
+	snapshot := m.GetCurrent().Clone()
+	if err := modify(&snapshot); err != nil {
+		return err
 	}
 
-	return DataSnapshot{Users: users, Teams: content.Teams}, nil
+	if err := writeDataFile(m.config.Paths[0], snapshot); err != nil {
+		return fmt.Errorf("update data file: %w", err)
+	}
+
+	// read the file back, so the snapshot carries the revision of what was written. The write
+	// succeeded if that fails, e.g. after a concurrent edit, and the status reports the file like
+	// any broken edit.
+	snapshot, err := m.load()
+	if err != nil {
+		m.failed(err)
+		slog.Error("reloading data files after update failed", slog.Any("error", err))
+		return nil
+	}
+
+	m.snapshot.Store(snapshot)
+	m.succeeded(snapshot)
+	m.track(snapshot)
+	return nil
+}
+
+func (m *DataMonitor) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
 }
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDataMonitorStatus(t *testing.T) {
+	key := testUserKey(t)
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	writeTestFile(t, path, "teams:\n  team-1: [alice]\nusers:\n  - name: alice\n    key: "+key+"\n")
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	initial := monitor.Status()
+	if !initial.Healthy() || initial.Revision == "" || initial.Users != 1 || initial.Teams != 1 {
+		t.Fatalf("unexpected initial status %+v", initial)
+	}
+
+	server := NewServer(ServerConfig{ReportDegradedHealth: true}, nil, NewYAMLFileDataRepository(monitor))
+	server.InitRoutes()
+
+	health := func() int {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+		return recorder.Code
+	}
+	if code := health(); code != http.StatusOK {
+		t.Errorf("expected healthy server, got %d", code)
+	}
+
+	writeTestFile(t, path, "teams:\n  team-1: [alice, bob]\nusers:\n  - name: alice\n    key: "+key+"\n")
+	waitFor(t, func() bool { return !monitor.Status().Healthy() }, "expected invalid file to be reported")
+
+	degraded := monitor.Status()
+	if degraded.Revision != initial.Revision || degraded.LastSuccess != initial.LastSuccess {
+		t.Errorf("expected last good snapshot to be reported, got %+v", degraded)
+	}
+	if code := health(); code != http.StatusServiceUnavailable {
+		t.Errorf("expected degraded health, got %d", code)
+	}
+
+	writeTestFile(t, path, "teams: {}\nusers:\n  - name: alice\n    key: "+key+"\n")
+	waitFor(t, func() bool { return monitor.Status().Healthy() }, "expected fixed file to recover")
+
+	if recovered := monitor.Status(); recovered.Teams != 0 || recovered.Revision == initial.Revision {
+		t.Errorf("expected new snapshot, got %+v", recovered)
+	}
+}
+
+func testDataFile(key string, team string) string {
+	return "teams:\n  " + team + ": [alice]\nusers:\n  - name: alice\n    key: " + key + "\n"
+}
+
+func hasTeam(monitor *DataMonitor, team string) bool {
+	_, ok := monitor.GetCurrent().Teams[team]
+	return ok
+}
+
+func TestDataMonitorDebouncesBursts(t *testing.T) {
+	key := testUserKey(t)
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	writeTestFile(t, path, testDataFile(key, "team-1"))
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: 300 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	// an editor saving in several steps, with an invalid intermediate state
+	writeTestFile(t, path, "teams:\n  team-2: [alice]\n")
+	writeTestFile(t, path, testDataFile(key, "team-2")[:20])
+	writeTestFile(t, path, testDataFile(key, "team-2"))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !hasTeam(monitor, "team-2") {
+		if !monitor.Status().Healthy() {
+			t.Fatalf("intermediate state was loaded: %s", monitor.Status().LastError)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected final state to be loaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDataMonitorAtomicRename(t *testing.T) {
+	key := testUserKey(t)
+	directory := t.TempDir()
+	path := filepath.Join(directory, "data.yaml")
+	writeTestFile(t, path, testDataFile(key, "team-1"))
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	for _, team := range []string{"team-2", "team-3"} {
+		tmp := filepath.Join(directory, ".data.yaml.swp")
+		writeTestFile(t, tmp, testDataFile(key, team))
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("rename: %v", err)
+		}
+		waitFor(t, func() bool { return hasTeam(monitor, team) }, "expected replaced file to be loaded: "+team)
+	}
+}
+
+func TestDataMonitorConfigMapSymlinkSwap(t *testing.T) {
+	key := testUserKey(t)
+	directory := t.TempDir()
+
+	// the layout kubelet uses for mounted ConfigMaps
+	writeTestFile(t, filepath.Join(directory, "..2024_01", "data.yaml"), testDataFile(key, "team-1"))
+	if err := os.Symlink("..2024_01", filepath.Join(directory, "..data")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join("..data", "data.yaml"), filepath.Join(directory, "data.yaml")); err != nil {
+		t.Fatalf("symlink: %v", err)
+	}
+
+	for i, paths := range [][]string{{directory}, {filepath.Join(directory, "data.yaml")}} {
+		monitor, err := NewDataMonitor(DataMonitorConfig{Paths: paths, Debounce: 10 * time.Millisecond})
+		if err != nil {
+			t.Fatalf("create monitor: %v", err)
+		}
+
+		previous := monitor.GetCurrent().Teams
+		team := fmt.Sprintf("team-%d", i+2)
+		update := filepath.Join(directory, "..2024_"+team)
+		writeTestFile(t, filepath.Join(update, "data.yaml"), testDataFile(key, team))
+		if err := os.Symlink(filepath.Base(update), filepath.Join(directory, "..data_tmp")); err != nil {
+			t.Fatalf("symlink: %v", err)
+		}
+		if err := os.Rename(filepath.Join(directory, "..data_tmp"), filepath.Join(directory, "..data")); err != nil {
+			t.Fatalf("rename: %v", err)
+		}
+
+		waitFor(t, func() bool { return hasTeam(monitor, team) }, fmt.Sprintf("expected swapped data to be loaded for %v", paths))
+		if len(monitor.GetCurrent().Teams) != 1 {
+			t.Errorf("expected previous teams %v to be replaced, got %v", previous, monitor.GetCurrent().Teams)
+		}
+		_ = monitor.Close()
+	}
+}
+
+func TestDataMonitorPollsWhenWatchIsLost(t *testing.T) {
+	key := testUserKey(t)
+	directory := filepath.Join(t.TempDir(), "data")
+	writeTestFile(t, filepath.Join(directory, "data.yaml"), testDataFile(key, "team-1"))
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{
+		Paths:        []string{directory},
+		Debounce:     10 * time.Millisecond,
+		PollInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	if err := os.RemoveAll(directory); err != nil {
+		t.Fatalf("remove directory: %v", err)
+	}
+	waitFor(t, func() bool { return !monitor.Status().Healthy() }, "expected missing directory to be reported")
+	if !hasTeam(monitor, "team-1") {
+		t.Error("expected last good snapshot to be kept")
+	}
+
+	writeTestFile(t, filepath.Join(directory, "data.yaml"), testDataFile(key, "team-2"))
+	waitFor(t, func() bool { return hasTeam(monitor, "team-2") && monitor.Status().Healthy() }, "expected polling to load the recreated directory")
+
+	// the watch is established again, so changes are picked up without polling
+	writeTestFile(t, filepath.Join(directory, "data.yaml"), testDataFile(key, "team-3"))
+	waitFor(t, func() bool { return hasTeam(monitor, "team-3") }, "expected watch to pick up changes again")
+}
+
+func TestDataMonitorRejectsGlobbedDirectories(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a", "data.yaml"), testDataFile(testUserKey(t), "team-1"))
+
+	if _, err := NewDataMonitor(DataMonitorConfig{Paths: []string{filepath.Join(dir, "*", "data.yaml")}}); !errors.Is(err, ErrUnwatchableGlob) {
+		t.Errorf("expected the glob in the directory to be rejected, got %v", err)
+	}
+}
+
+func TestDataMonitorUpdateRejectsInvalidData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	content := testDataFile(testUserKey(t), "team-1")
+	writeTestFile(t, path, content)
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: time.Hour})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	// a key of the wrong size would keep the file from loading again
+	err = monitor.Update(func(snapshot *DataSnapshot) error {
+		snapshot.Users["bob"] = []byte{1}
+		return nil
+	})
+	if err == nil {
+		t.Error("expected the update to be rejected")
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read data file: %v", err)
+	}
+	if string(written) != content {
+		t.Errorf("expected the data file to be untouched, got %q", written)
+	}
+	if !monitor.Status().Healthy() {
+		t.Error("expected the rejected update to leave the status healthy")
+	}
+}
+
+func TestYAMLFileDataRepositoryLowercasesTeams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.yaml")
+	writeTestFile(t, path, testDataFile(testUserKey(t), "team-1"))
+
+	monitor, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: time.Hour})
+	if err != nil {
+		t.Fatalf("create monitor: %v", err)
+	}
+	defer monitor.Close()
+
+	repository := NewYAMLFileDataRepository(monitor)
+	for _, team := range []string{"Team-1", "Team-X"} {
+		if err := repository.PutTeam(team, []string{"alice"}); err != nil {
+			t.Fatalf("put %s: %v", team, err)
+		}
+		if _, ok := repository.GetTeamMembers(team); !ok {
+			t.Errorf("expected %s to be found", team)
+		}
+	}
+
+	// a restart reads the written file again
+	restarted, err := NewDataMonitor(DataMonitorConfig{Paths: []string{path}, Debounce: time.Hour})
+	if err != nil {
+		t.Fatalf("reload written file: %v", err)
+	}
+	defer restarted.Close()
+
+	if teams := restarted.GetCurrent().Teams; len(teams) != 2 || !hasTeam(restarted, "team-1") || !hasTeam(restarted, "team-x") {
+		t.Errorf("unexpected teams %v", teams)
+	}
+}
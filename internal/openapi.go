@@ -0,0 +1,19 @@
+package internal
+
+import (
+	_ "embed"
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// openAPIDocument describes every route of the server. The contract test checks the responses of
+// the server against it, so it has to be updated together with the handlers.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+func buildOpenAPIHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, openAPIDocument)
+	}
+}
@@ -0,0 +1,443 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// contractRepository adds a status and changes to the sqlite repository, so every route of the
+// server is available.
+type contractRepository struct {
+	*SQLiteDataRepository
+}
+
+func (r contractRepository) Status() ReloadStatus {
+	now := time.Now()
+	return ReloadStatus{LastAttempt: now, LastSuccess: now, Revision: "1", Users: 2, Teams: 1}
+}
+
+func (r contractRepository) Changes() []api.DataChange {
+	return []api.DataChange{{Time: time.Now(), Revision: "1", TeamsAdded: []string{"team-1"}, MembersAdded: map[string][]string{"team-1": {"alice"}}}}
+}
+
+type noopRefresher struct{}
+
+func (noopRefresher) Refresh() error {
+	return nil
+}
+
+// contractChecker is a transport that checks requests and responses against the OpenAPI document
+// and records the operations and status codes that were seen.
+type contractChecker struct {
+	t        *testing.T
+	document map[string]any
+	mutex    sync.Mutex
+	seen     map[string]bool
+}
+
+func (c *contractChecker) RoundTrip(request *http.Request) (*http.Response, error) {
+	path, operation := c.operation(request.Method, request.URL.Path)
+	if operation == nil {
+		c.t.Errorf("%s %s is not documented", request.Method, request.URL.Path)
+		return http.DefaultTransport.RoundTrip(request)
+	}
+
+	var requestBody []byte
+	if request.Body != nil {
+		requestBody, _ = io.ReadAll(request.Body)
+		request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	response, err := http.DefaultTransport.RoundTrip(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests that the server rejects as malformed are sent on purpose
+	schema := c.lookup(operation, "requestBody", "content", "application/json", "schema")
+	if schema != nil && len(requestBody) > 0 && response.StatusCode != http.StatusBadRequest {
+		c.check(fmt.Sprintf("request of %s %s", request.Method, path), schema, requestBody)
+	}
+
+	status := fmt.Sprint(response.StatusCode)
+	name := fmt.Sprintf("%s %s %s", request.Method, path, status)
+	c.mutex.Lock()
+	c.seen[name] = true
+	c.mutex.Unlock()
+
+	documented := c.lookup(operation, "responses", status)
+	if documented == nil {
+		c.t.Errorf("%s is not documented", name)
+		return response, nil
+	}
+
+	body, _ := io.ReadAll(response.Body)
+	response.Body = io.NopCloser(bytes.NewReader(body))
+
+	content, _ := c.lookup(documented, "content").(map[string]any)
+	if len(content) == 0 {
+		if len(body) > 0 {
+			c.t.Errorf("%s: expected no body, got %s", name, body)
+		}
+		return response, nil
+	}
+
+	mediaType, _, _ := strings.Cut(response.Header.Get("Content-Type"), ";")
+	if content[mediaType] == nil {
+		c.t.Errorf("%s: content type %q is not documented", name, mediaType)
+	} else if mediaType == "application/json" || mediaType == api.MIMEApplicationProblemJSON {
+		c.check(name, c.lookup(content[mediaType], "schema"), body)
+	}
+	return response, nil
+}
+
+// operation finds the operation of a request by matching the path against the templates of the
+// document.
+func (c *contractChecker) operation(method string, path string) (string, any) {
+	paths := c.document["paths"].(map[string]any)
+	for template, item := range paths {
+		if matchPathTemplate(template, path) {
+			return template, c.lookup(item, strings.ToLower(method))
+		}
+	}
+	return "", nil
+}
+
+func matchPathTemplate(template string, path string) bool {
+	templateSegments := strings.Split(template, "/")
+	pathSegments := strings.Split(path, "/")
+	if len(templateSegments) != len(pathSegments) {
+		return false
+	}
+	for i, segment := range templateSegments {
+		if !strings.HasPrefix(segment, "{") && segment != pathSegments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup follows keys from node, resolving $ref on the way.
+func (c *contractChecker) lookup(node any, keys ...string) any {
+	for _, key := range keys {
+		node = c.resolve(node)
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil
+		}
+		node = object[key]
+	}
+	return c.resolve(node)
+}
+
+func (c *contractChecker) resolve(node any) any {
+	object, ok := node.(map[string]any)
+	if !ok {
+		return node
+	}
+	ref, ok := object["$ref"].(string)
+	if !ok {
+		return node
+	}
+
+	var resolved any = c.document
+	for _, key := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		resolved = resolved.(map[string]any)[key]
+	}
+	return c.resolve(resolved)
+}
+
+func (c *contractChecker) check(name string, schema any, body []byte) {
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		c.t.Errorf("%s: invalid json %s: %v", name, body, err)
+		return
+	}
+	for _, problem := range c.validate(schema, value, "$") {
+		c.t.Errorf("%s: %s", name, problem)
+	}
+}
+
+// validate checks the subset of JSON schema used by the document. Properties that are not
+// documented are reported too, so the document stays complete.
+func (c *contractChecker) validate(schema any, value any, location string) []string {
+	object, _ := c.resolve(schema).(map[string]any)
+	problems := []string{}
+
+	switch object["type"] {
+	case "object":
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected object, got %T", location, value)}
+		}
+		properties, _ := object["properties"].(map[string]any)
+		required, _ := object["required"].([]any)
+		for _, name := range required {
+			if _, found := fields[name.(string)]; !found {
+				problems = append(problems, fmt.Sprintf("%s: missing %s", location, name))
+			}
+		}
+		for key, field := range fields {
+			propertySchema := properties[key]
+			if propertySchema == nil {
+				propertySchema = object["additionalProperties"]
+			}
+			if propertySchema == nil {
+				if properties != nil {
+					problems = append(problems, fmt.Sprintf("%s: undocumented property %s", location, key))
+				}
+				continue
+			}
+			problems = append(problems, c.validate(propertySchema, field, location+"."+key)...)
+		}
+	case "array":
+		items, ok := value.([]any)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected array, got %T", location, value)}
+		}
+		for i, item := range items {
+			problems = append(problems, c.validate(object["items"], item, fmt.Sprintf("%s[%d]", location, i))...)
+		}
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: expected string, got %T", location, value)}
+		}
+		if object["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, text); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: expected date-time, got %q", location, text))
+			}
+		}
+		if enum, ok := object["enum"].([]any); ok && !slices.Contains(enum, any(text)) {
+			problems = append(problems, fmt.Sprintf("%s: %q is not one of %v", location, text, enum))
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			return []string{fmt.Sprintf("%s: expected integer, got %v", location, value)}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []string{fmt.Sprintf("%s: expected boolean, got %T", location, value)}
+		}
+	}
+	return problems
+}
+
+func TestServerMatchesOpenAPIDocument(t *testing.T) {
+	document := map[string]any{}
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+
+	sqlRepository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer sqlRepository.Close()
+
+	pub, priv := newTestKey(t)
+	_, other := newTestKey(t)
+	err = sqlRepository.Import(DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": pub, "bob": pub},
+		Roles: map[string][]Role{"alice": {{Name: RoleAdmin}}, "bob": {{Name: RoleReader}}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	sink, err := NewFileAuditSink(AuditFileConfig{Path: filepath.Join(t.TempDir(), "audit.log")})
+	if err != nil {
+		t.Fatalf("create sink: %v", err)
+	}
+	audit := NewAuditLog(sink)
+	defer audit.Close()
+
+	limiter := NewLoginLimiter(LoginLimiterConfig{User: RateLimit{Burst: 4, Refill: time.Hour}}, nil)
+	server, jwt := newTestServer(t, ServerConfig{LoginLimiter: limiter, Gateway: GatewayConfig{Cookie: "teams_token", TeamQuery: true}}, contractRepository{sqlRepository})
+	server.InitAudit(audit)
+	server.InitRefreshWebhook(noopRefresher{}, "secret")
+
+	checker := &contractChecker{t: t, document: document, seen: map[string]bool{}}
+	for _, route := range server.Routes() {
+		template := "/" + strings.TrimPrefix(route.Path, "/")
+		for _, name := range []string{"id", "username"} {
+			template = strings.ReplaceAll(template, ":"+name, "{"+name+"}")
+		}
+		if checker.lookup(document["paths"], template, strings.ToLower(route.Method)) == nil {
+			t.Errorf("route %s %s is not documented", route.Method, template)
+		}
+	}
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	httpClient := &http.Client{Transport: checker}
+
+	send := func(method string, path string, token string, body any) {
+		t.Helper()
+		var reader io.Reader
+		if body != nil {
+			encoded, _ := json.Marshal(body)
+			reader = bytes.NewReader(encoded)
+		}
+		request, _ := http.NewRequest(method, httpServer.URL+path, reader)
+		request.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := httpClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		_ = response.Body.Close()
+	}
+
+	login := func(username string, key ed25519.PrivateKey) {
+		now := time.Now()
+		send(http.MethodPost, "/login", "", api.LoginRequest{Username: username, Timestamp: now, Challenge: api.CreateChallenge(username, now, key)})
+	}
+
+	login("alice", other)
+	login("carol", priv)
+	login("alice", priv)
+	login("bob", priv)
+	login("bob", priv)
+	login("bob", priv)
+	login("bob", priv)
+	login("bob", priv)
+	send(http.MethodPost, "/login", "", "not a login request")
+
+	admin, _ := jwt.Create("alice", []Role{{Name: RoleAdmin}}, nil, time.Now())
+	reader, _ := jwt.Create("bob", []Role{{Name: RoleReader}}, nil, time.Now())
+	removed, _ := jwt.Create("carol", nil, nil, time.Now())
+
+	for _, path := range []string{"/verify?access_token=" + admin, "/verify?access_token=" + removed, "/verify?access_token=invalid", "/teams/team-1", "/teams/unknown", "/health", "/livez", "/readyz", "/status", "/changes", "/openapi.json"} {
+		send(http.MethodGet, path, "", nil)
+	}
+	send(http.MethodGet, "/forward-auth?team=team-1", admin, nil)
+	send(http.MethodGet, "/forward-auth?team=team-1", reader, nil)
+	send(http.MethodGet, "/forward-auth", "", nil)
+
+	send(http.MethodPut, "/teams/team-2", "", api.TeamRequest{Members: []string{"bob"}})
+	send(http.MethodPut, "/teams/team-2", reader, api.TeamRequest{Members: []string{"bob"}})
+	send(http.MethodPut, "/teams/team-2", admin, "not a team request")
+	send(http.MethodPut, "/teams/team-2", admin, api.TeamRequest{Members: []string{}})
+	send(http.MethodPut, "/teams/team-2", admin, api.TeamRequest{Members: []string{"carol"}})
+	send(http.MethodPut, "/teams/team-2", admin, api.TeamRequest{Members: []string{"bob"}})
+	send(http.MethodPut, "/teams/team-2/members/alice", admin, nil)
+	send(http.MethodPut, "/teams/team-2/members/carol", admin, nil)
+	send(http.MethodPut, "/teams/unknown/members/alice", admin, nil)
+	send(http.MethodPut, "/teams/team-2/members/alice", "", nil)
+	send(http.MethodPut, "/teams/team-2/members/alice", reader, nil)
+	send(http.MethodDelete, "/teams/team-2/members/alice", admin, nil)
+	send(http.MethodDelete, "/teams/team-2/members/bob", admin, nil)
+	send(http.MethodDelete, "/teams/team-2/members/carol", admin, nil)
+	send(http.MethodDelete, "/teams/unknown/members/bob", admin, nil)
+	send(http.MethodDelete, "/teams/team-2/members/bob", "", nil)
+	send(http.MethodDelete, "/teams/team-2/members/bob", reader, nil)
+	send(http.MethodDelete, "/teams/team-2", "", nil)
+	send(http.MethodDelete, "/teams/team-2", reader, nil)
+	send(http.MethodDelete, "/teams/team-2", admin, nil)
+	send(http.MethodDelete, "/teams/team-2", admin, nil)
+
+	send(http.MethodGet, "/audit?limit=5", admin, nil)
+	send(http.MethodGet, "/audit?since=yesterday", admin, nil)
+	send(http.MethodGet, "/audit", reader, nil)
+	send(http.MethodGet, "/audit", "", nil)
+
+	body := []byte(`{"ref":"refs/heads/main"}`)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	tooLarge := []byte(`{"padding":"` + strings.Repeat(" ", refreshWebhookMaxBody) + `"}`)
+	webhooks := []struct {
+		body      []byte
+		signature string
+	}{
+		{body, "sha256=" + hex.EncodeToString(mac.Sum(nil))},
+		{body, "sha256=00"},
+		{tooLarge, "sha256=00"},
+	}
+	for _, webhook := range webhooks {
+		request, _ := http.NewRequest(http.MethodPost, httpServer.URL+"/webhooks/refresh", bytes.NewReader(webhook.body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Hub-Signature-256", webhook.signature)
+		if response, err := httpClient.Do(request); err == nil {
+			_ = response.Body.Close()
+		}
+	}
+
+	seen := make([]string, 0, len(checker.seen))
+	for name := range checker.seen {
+		seen = append(seen, name)
+	}
+	sort.Strings(seen)
+	t.Logf("checked %d responses: %s", len(seen), strings.Join(seen, ", "))
+
+	for _, expected := range []string{"POST /login 200", "POST /login 401", "POST /login 404", "POST /login 429", "GET /status 200", "GET /changes 200", "GET /audit 200", "GET /forward-auth 200", "GET /forward-auth 403", "PUT /teams/{id} 204", "POST /webhooks/refresh 204", "POST /webhooks/refresh 413"} {
+		if !checker.seen[expected] {
+			t.Errorf("expected %s to be checked", expected)
+		}
+	}
+}
+
+func TestOpenAPIDocumentsClientCertificateRoutes(t *testing.T) {
+	document := map[string]any{}
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatalf("decode document: %v", err)
+	}
+
+	repository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer repository.Close()
+
+	newServer := func(config ServerConfig) *Server {
+		server, _ := newTestServer(t, config, contractRepository{repository})
+		server.InitAudit(NewAuditLog())
+		server.InitRefreshWebhook(noopRefresher{}, "secret")
+		return server
+	}
+
+	// every route can be listed in tls.client_certificate_routes
+	routes := []string{}
+	for _, route := range newServer(ServerConfig{}).Routes() {
+		routes = append(routes, route.Path)
+	}
+	server := newServer(ServerConfig{ClientCertificateRoutes: routes})
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	checker := &contractChecker{t: t, document: document, seen: map[string]bool{}}
+	httpClient := &http.Client{Transport: checker}
+	for _, route := range server.Routes() {
+		path := strings.NewReplacer(":id", "team-1", ":username", "alice").Replace(route.Path)
+		request, _ := http.NewRequest(route.Method, httpServer.URL+"/"+strings.TrimPrefix(path, "/"), nil)
+		response, err := httpClient.Do(request)
+		if err != nil {
+			t.Fatalf("%s %s: %v", route.Method, path, err)
+		}
+		_ = response.Body.Close()
+		if response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected a client certificate to be required, got %d", route.Method, path, response.StatusCode)
+		}
+	}
+}
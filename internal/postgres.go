@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pscheid/teams/api"
+	"io/fs"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+const (
+	postgresNotifyChannel   = "teams_data_changed"
+	postgresMigrationLock   = "SELECT pg_advisory_lock(hashtext('teams-server-migrations'))"
+	postgresMigrationUnlock = "SELECT pg_advisory_unlock(hashtext('teams-server-migrations'))"
+)
+
+type PostgresConfig struct {
+	URL           string
+	RetryInterval time.Duration
+}
+
+// PostgresDataRepository serves reads from an in-memory snapshot of the database. The snapshot is
+// reloaded whenever the triggers of the schema announce a change on postgresNotifyChannel.
+type PostgresDataRepository struct {
+	*SnapshotDataRepository
+	reloadTracker
+	changeTracker
+	config   PostgresConfig
+	pool     *pgxpool.Pool
+	sql      *SQLDataRepository
+	snapshot atomic.Value
+	// refreshes keeps a slow refresh from storing an older snapshot over a newer one
+	refreshes sync.Mutex
+	stop      context.CancelFunc
+	done      chan struct{}
+}
+
+func NewPostgresDataRepository(config PostgresConfig) (*PostgresDataRepository, error) {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+
+	poolConfig, err := pgxpool.ParseConfig(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres database: %w", err)
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
+	if err != nil {
+		return nil, fmt.Errorf("open postgres database: %w", err)
+	}
+
+	db := stdlib.OpenDBFromPool(pool)
+
+	migrations, err := fs.Sub(postgresMigrations, "migrations/postgres")
+	if err != nil {
+		_ = db.Close()
+		pool.Close()
+		return nil, err
+	}
+
+	if err := migrateDatabase(db, migrations, postgresMigrationLock, postgresMigrationUnlock); err != nil {
+		_ = db.Close()
+		pool.Close()
+		return nil, fmt.Errorf("open postgres database: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &PostgresDataRepository{
+		config: config,
+		pool:   pool,
+		sql:    NewSQLDataRepository(db),
+		stop:   cancel,
+		done:   make(chan struct{}),
+	}
+	r.SnapshotDataRepository = NewSnapshotDataRepository(r)
+
+	if err := r.refresh(); err != nil {
+		cancel()
+		_ = db.Close()
+		pool.Close()
+		return nil, fmt.Errorf("open postgres database: %w", err)
+	}
+
+	go r.listen(ctx)
+	return r, nil
+}
+
+func (r *PostgresDataRepository) GetCurrent() DataSnapshot {
+	return r.snapshot.Load().(DataSnapshot)
+}
+
+func (r *PostgresDataRepository) Status() ReloadStatus {
+	return r.reloadTracker.Status()
+}
+
+func (r *PostgresDataRepository) Changes() []api.DataChange {
+	return r.changeTracker.Changes()
+}
+
+func (r *PostgresDataRepository) PutTeam(team string, members []string) error {
+	return r.write(func() error { return r.sql.PutTeam(team, members) })
+}
+
+func (r *PostgresDataRepository) DeleteTeam(team string) error {
+	return r.write(func() error { return r.sql.DeleteTeam(team) })
+}
+
+func (r *PostgresDataRepository) AddTeamMember(team string, username string) error {
+	return r.write(func() error { return r.sql.AddTeamMember(team, username) })
+}
+
+func (r *PostgresDataRepository) RemoveTeamMember(team string, username string) error {
+	return r.write(func() error { return r.sql.RemoveTeamMember(team, username) })
+}
+
+func (r *PostgresDataRepository) Import(snapshot DataSnapshot) error {
+	return r.write(func() error { return r.sql.Import(snapshot) })
+}
+
+func (r *PostgresDataRepository) Close() error {
+	r.stop()
+	<-r.done
+
+	err := r.sql.Close()
+	r.pool.Close()
+	return err
+}
+
+// write refreshes the snapshot right after a successful write, so callers read their own writes
+// without waiting for the notification to arrive.
+func (r *PostgresDataRepository) write(operation func() error) error {
+	if err := operation(); err != nil {
+		return err
+	}
+	if err := r.refresh(); err != nil {
+		slog.Error("postgres refresh after write failed", slog.Any("error", err))
+	}
+	return nil
+}
+
+func (r *PostgresDataRepository) refresh() error {
+	r.refreshes.Lock()
+	defer r.refreshes.Unlock()
+
+	snapshot, err := r.sql.Snapshot()
+	if err != nil {
+		err = fmt.Errorf("refresh postgres snapshot: %w", err)
+		r.failed(err)
+		return err
+	}
+	r.snapshot.Store(snapshot)
+	r.succeeded(snapshot)
+	r.track(snapshot)
+	return nil
+}
+
+func (r *PostgresDataRepository) listen(ctx context.Context) {
+	defer close(r.done)
+
+	for {
+		err := r.waitForNotifications(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.Error("listening for postgres data changes failed", slog.Any("error", err), slog.Duration("retry_interval", r.config.RetryInterval))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.config.RetryInterval):
+		}
+	}
+}
+
+func (r *PostgresDataRepository) waitForNotifications(ctx context.Context) error {
+	pooled, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// the listening connection is taken out of the pool, so no other caller receives our notifications
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+postgresNotifyChannel); err != nil {
+		return err
+	}
+
+	// changes may have happened while no connection was listening
+	if err := r.refresh(); err != nil {
+		return err
+	}
+
+	for {
+		if _, err := conn.WaitForNotification(ctx); err != nil {
+			return err
+		}
+		if err := r.refresh(); err != nil {
+			slog.Error("postgres refresh after notification failed", slog.Any("error", err))
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"net/http"
+)
+
+func tokenProblem(err error) string {
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return api.ProblemTokenExpired
+	}
+	return api.ProblemInvalidToken
+}
+
+func writeProblem(c echo.Context, status int, code string, detail string) error {
+	problem := api.NewProblem(status, code, detail)
+	problem.Instance = c.Request().URL.Path
+	problem.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	c.Response().Header().Set(echo.HeaderContentType, api.MIMEApplicationProblemJSON)
+	return c.JSON(status, problem)
+}
+
+// handleError answers errors that handlers return and requests that match no route with a
+// problem, instead of the message that echo responds with.
+func (s *Server) handleError(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, code := http.StatusInternalServerError, api.ProblemInternalError
+	if httpError := (*echo.HTTPError)(nil); errors.As(err, &httpError) {
+		status = httpError.Code
+		switch {
+		case status == http.StatusNotFound:
+			code = api.ProblemNotFound
+		case status == http.StatusMethodNotAllowed:
+			code = api.ProblemMethodNotAllowed
+		case status < http.StatusInternalServerError:
+			code = api.ProblemInvalidRequest
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		s.logger(c).Error("request failed", slog.Any("error", err))
+	}
+	if err := writeProblem(c, status, code, ""); err != nil {
+		s.logger(c).Error("writing problem failed", slog.Any("error", err))
+	}
+}
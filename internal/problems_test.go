@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"github.com/pscheid/teams/api"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerAnswersWithProblems(t *testing.T) {
+	pub, priv := newTestKey(t)
+	server, jwt := newTestServer(t, ServerConfig{}, NewSnapshotDataRepository(staticSnapshot{Users: map[string]ed25519.PublicKey{"alice": pub}}))
+
+	stale := time.Now().Add(-time.Hour)
+	login, _ := json.Marshal(api.LoginRequest{Username: "alice", Timestamp: stale, Challenge: api.CreateChallenge("alice", stale, priv)})
+	expired, _ := jwt.Create("alice", nil, nil, time.Now().Add(-2*time.Hour))
+
+	requests := []struct {
+		request *http.Request
+		status  int
+		code    string
+	}{
+		{httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(login)), http.StatusUnauthorized, api.ProblemChallengeExpired},
+		{httptest.NewRequest(http.MethodGet, "/verify?access_token="+expired, nil), http.StatusBadRequest, api.ProblemTokenExpired},
+		{httptest.NewRequest(http.MethodGet, "/teams/unknown", nil), http.StatusNotFound, api.ProblemTeamNotFound},
+		{httptest.NewRequest(http.MethodGet, "/unknown", nil), http.StatusNotFound, api.ProblemNotFound},
+	}
+	for _, r := range requests {
+		r.request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r.request)
+
+		problem := api.Problem{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("decode problem: %v", err)
+		}
+		if recorder.Code != r.status || problem.Status != r.status || problem.Code != r.code || recorder.Header().Get("Content-Type") != api.MIMEApplicationProblemJSON {
+			t.Errorf("%s %s: expected %d %s, got %d %s", r.request.Method, r.request.URL.Path, r.status, r.code, recorder.Code, recorder.Body)
+		}
+		if problem.Instance != r.request.URL.Path || problem.RequestID == "" {
+			t.Errorf("expected problem to identify the request, got %+v", problem)
+		}
+	}
+
+	if err := error(&api.ProblemError{Problem: api.Problem{Code: api.ProblemTokenExpired}}); !errors.Is(err, api.ErrTokenExpired) {
+		t.Errorf("expected problem error to match its code, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RoleAdmin          = "admin"
+	RoleTeamMaintainer = "team-maintainer"
+	RoleReader         = "reader"
+
+	RoleScopeSeparator = ":"
+)
+
+type Role struct {
+	Name string
+	Team string
+}
+
+func ParseRole(value string) (Role, error) {
+	name, team, scoped := strings.Cut(value, RoleScopeSeparator)
+
+	switch name {
+	case RoleAdmin, RoleReader:
+		if scoped {
+			return Role{}, fmt.Errorf("parse role %q: role %s cannot be scoped to a team", value, name)
+		}
+	case RoleTeamMaintainer:
+		if team == "" {
+			return Role{}, fmt.Errorf("parse role %q: role %s requires a team", value, name)
+		}
+	default:
+		return Role{}, fmt.Errorf("parse role %q: unknown role", value)
+	}
+
+	return Role{Name: name, Team: team}, nil
+}
+
+func ParseRoles(values []string) ([]Role, error) {
+	roles := make([]Role, 0, len(values))
+	for _, value := range values {
+		role, err := ParseRole(value)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (r Role) String() string {
+	if r.Team == "" {
+		return r.Name
+	}
+	return r.Name + RoleScopeSeparator + r.Team
+}
+
+func IsAdmin(roles []Role) bool {
+	for _, r := range roles {
+		if r.Name == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func CanEditTeam(roles []Role, team string) bool {
+	for _, r := range roles {
+		if r.Name == RoleAdmin {
+			return true
+		}
+		if r.Name == RoleTeamMaintainer && r.Team == team {
+			return true
+		}
+	}
+	return false
+}
+
+func formatRoles(roles []Role) []string {
+	values := make([]string, 0, len(roles))
+	for _, r := range roles {
+		values = append(values, r.String())
+	}
+	return values
+}
@@ -0,0 +1,51 @@
+package internal
+
+import "testing"
+
+func TestParseRole(t *testing.T) {
+	valid := map[string]Role{
+		"admin":                  {Name: RoleAdmin},
+		"reader":                 {Name: RoleReader},
+		"team-maintainer:team-1": {Name: RoleTeamMaintainer, Team: "team-1"},
+	}
+	for value, expected := range valid {
+		role, err := ParseRole(value)
+		if err != nil {
+			t.Fatalf("parse %q: %v", value, err)
+		}
+		if role != expected {
+			t.Errorf("parse %q: expected %+v, got %+v", value, expected, role)
+		}
+		if role.String() != value {
+			t.Errorf("expected %q to round-trip, got %q", value, role.String())
+		}
+	}
+
+	for _, value := range []string{"", "root", "admin:team-1", "team-maintainer", "team-maintainer:"} {
+		if _, err := ParseRole(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestCanEditTeam(t *testing.T) {
+	admin := []Role{{Name: RoleAdmin}}
+	maintainer := []Role{{Name: RoleReader}, {Name: RoleTeamMaintainer, Team: "team-1"}}
+	reader := []Role{{Name: RoleReader}}
+
+	if !CanEditTeam(admin, "team-2") {
+		t.Error("expected admin to edit any team")
+	}
+	if !CanEditTeam(maintainer, "team-1") {
+		t.Error("expected maintainer to edit own team")
+	}
+	if CanEditTeam(maintainer, "team-2") {
+		t.Error("expected maintainer not to edit foreign team")
+	}
+	if CanEditTeam(reader, "team-1") {
+		t.Error("expected reader not to edit any team")
+	}
+	if IsAdmin(maintainer) {
+		t.Error("expected maintainer not to be admin")
+	}
+}
@@ -1,67 +1,473 @@
 package internal
 
 import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pscheid/teams/api"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/labstack/echo/otelecho"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
+type ServerConfig struct {
+	// Logger receives the request log and errors, slog.Default() is used if it is nil.
+	Logger               *slog.Logger
+	ReportDegradedHealth bool
+	// ChallengeMaxAge is how far the timestamp of a login challenge may be from the server time.
+	ChallengeMaxAge time.Duration
+	// ClientCertificateRoutes require a verified client certificate. Entries are route paths like
+	// /audit, optionally prefixed with a method like PUT /teams/:id.
+	ClientCertificateRoutes []string
+	// LoginLimiter limits login attempts, which are not limited if it is nil.
+	LoginLimiter *LoginLimiter
+	// UniformLoginErrors answers logins of unknown users with 401 like invalid signatures, so
+	// usernames cannot be enumerated.
+	UniformLoginErrors bool
+	// Gateway configures GET /forward-auth.
+	Gateway GatewayConfig
+	// TrustedProxies may set X-Forwarded-For. Without them, the address of the connection is the
+	// address of the client, so clients cannot choose the address that logins are limited by.
+	TrustedProxies []*net.IPNet
+}
+
 type Server struct {
 	*echo.Echo
+	config     ServerConfig
 	repository DataRepository
 	jwt        *JwtHelper
+	audit      *AuditLog
+	metrics    *Metrics
+	stopping   atomic.Bool
 }
 
-func NewServer(jwt *JwtHelper, repository DataRepository) *Server {
-	return &Server{
+func NewServer(config ServerConfig, jwt *JwtHelper, repository DataRepository) *Server {
+	if config.ChallengeMaxAge <= 0 {
+		config.ChallengeMaxAge = time.Minute
+	}
+
+	if config.Logger == nil {
+		config.Logger = slog.Default()
+	}
+
+	s := &Server{
 		Echo:       echo.New(),
+		config:     config,
 		repository: repository,
 		jwt:        jwt,
 	}
+	s.HideBanner = true
+	s.HidePort = true
+	s.HTTPErrorHandler = s.handleError
+	s.IPExtractor = buildIPExtractor(config.TrustedProxies)
+	s.Use(middleware.RequestID())
+	s.Use(s.buildRequestLogMiddleware())
+	if len(config.ClientCertificateRoutes) > 0 {
+		s.Use(s.buildClientCertificateMiddleware())
+	}
+	return s
+}
+
+func buildIPExtractor(trustedProxies []*net.IPNet) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, network := range trustedProxies {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// ParseTrustedProxies parses addresses and CIDR ranges like 10.0.0.1 or 10.0.0.0/8.
+func ParseTrustedProxies(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * len(ip)
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// StartTLSConfig serves HTTPS with the given configuration. Unlike StartTLS, it supports
+// certificates that are loaded through GetCertificate.
+func (s *Server) StartTLSConfig(address string, config *tls.Config) error {
+	s.TLSServer.Addr = address
+	s.TLSServer.TLSConfig = config
+	return s.StartServer(s.TLSServer)
+}
+
+func (s *Server) buildClientCertificateMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			route := c.Path()
+			if !slices.Contains(s.config.ClientCertificateRoutes, route) && !slices.Contains(s.config.ClientCertificateRoutes, c.Request().Method+" "+route) {
+				return next(c)
+			}
+
+			// the handshake already verified the chain, if a certificate was presented
+			if state := c.Request().TLS; state == nil || len(state.VerifiedChains) == 0 {
+				return writeProblem(c, http.StatusUnauthorized, api.ProblemClientCertificateRequired, "")
+			}
+			return next(c)
+		}
+	}
+}
+
+// buildRequestLogMiddleware logs every request once it was handled. Handlers log through
+// s.logger(c), so their messages carry the same request ID.
+func (s *Server) buildRequestLogMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			level := slog.LevelInfo
+			if c.Response().Status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+
+			s.logger(c).Log(c.Request().Context(), level, "request",
+				slog.String("method", c.Request().Method),
+				slog.String("path", c.Request().URL.Path),
+				slog.Int("status", c.Response().Status),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_ip", c.RealIP()),
+			)
+			return nil
+		}
+	}
+}
+
+func (s *Server) logger(c echo.Context) *slog.Logger {
+	return s.config.Logger.With(slog.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)))
 }
 
 func (s *Server) InitRoutes() {
+	s.GET("openapi.json", buildOpenAPIHandler())
 	s.GET("health", s.buildHealthHandler())
+	s.GET("livez", s.buildLivenessHandler())
+	s.GET("readyz", s.buildReadinessHandler())
+	s.GET("status", s.buildStatusHandler())
+	s.GET("changes", s.buildChangesHandler())
 	s.POST("login", s.buildLoginHandler())
 	s.GET("verify", s.buildVerifyHandler())
+	s.GET("forward-auth", s.buildForwardAuthHandler())
 	s.GET("teams/:id", s.buildTeamHandler())
+
+	if repository, ok := s.repository.(WritableDataRepository); ok {
+		authenticate := s.buildAuthenticationMiddleware()
+		requireAdmin := buildAuthorizationMiddleware(isAdmin)
+		requireTeamEditor := buildAuthorizationMiddleware(canEditTeam)
+
+		s.PUT("teams/:id", s.buildPutTeamHandler(repository), authenticate, buildAuthorizationMiddleware(s.canPutTeam))
+		s.DELETE("teams/:id", s.buildDeleteTeamHandler(repository), authenticate, requireAdmin)
+		s.PUT("teams/:id/members/:username", s.buildAddTeamMemberHandler(repository), authenticate, requireTeamEditor)
+		s.DELETE("teams/:id/members/:username", s.buildRemoveTeamMemberHandler(repository), authenticate, requireTeamEditor)
+	}
 }
 
 func (s *Server) buildHealthHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.config.ReportDegradedHealth {
+			if reporter, ok := s.repository.(StatusReporter); ok && !reporter.Status().Healthy() {
+				return c.String(http.StatusServiceUnavailable, "degraded")
+			}
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+}
+
+func (s *Server) buildLivenessHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		return c.String(http.StatusOK, "ok")
 	}
 }
 
+func (s *Server) buildReadinessHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if s.stopping.Load() {
+			return c.String(http.StatusServiceUnavailable, "shutting down")
+		}
+		return c.String(http.StatusOK, "ok")
+	}
+}
+
+// BeginShutdown makes /readyz fail, while requests are still served until Shutdown is called.
+func (s *Server) BeginShutdown() {
+	s.stopping.Store(true)
+}
+
+type Refresher interface {
+	Refresh() error
+}
+
+func (s *Server) InitRefreshWebhook(refresher Refresher, secret string) {
+	s.POST("webhooks/refresh", s.buildRefreshWebhookHandler(refresher, []byte(secret)))
+}
+
+// InitAudit records logins, token verifications, team lookups and data writes in the audit log,
+// and serves it to admins through GET /audit.
+func (s *Server) InitAudit(audit *AuditLog) {
+	s.audit = audit
+	s.GET("audit", s.buildAuditHandler(), s.buildAuthenticationMiddleware(), buildAuthorizationMiddleware(isAdmin))
+}
+
+func (s *Server) buildAuditHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		filter := api.AuditFilter{User: c.QueryParam("user"), Limit: 100}
+
+		for name, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			if c.QueryParam(name) == "" {
+				continue
+			}
+			parsed, err := time.Parse(time.RFC3339, c.QueryParam(name))
+			if err != nil {
+				return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, name+" must be an RFC 3339 timestamp")
+			}
+			*value = parsed
+		}
+
+		if limit := c.QueryParam("limit"); limit != "" {
+			parsed, err := strconv.Atoi(limit)
+			if err != nil || parsed <= 0 {
+				return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "limit must be a positive number")
+			}
+			filter.Limit = parsed
+		}
+
+		events, err := s.audit.Query(filter)
+		if errors.Is(err, ErrAuditQueryUnsupported) {
+			return writeProblem(c, http.StatusNotFound, api.ProblemNotSupported, err.Error())
+		}
+		if err != nil {
+			s.logger(c).Error("querying audit log failed", slog.Any("error", err))
+			return writeProblem(c, http.StatusInternalServerError, api.ProblemInternalError, "")
+		}
+
+		return c.JSON(http.StatusOK, api.AuditResponse{Events: events})
+	}
+}
+
+func (s *Server) recordAudit(c echo.Context, event api.AuditEvent) {
+	event.RemoteIP = c.RealIP()
+	event.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	s.audit.Record(event)
+	s.metrics.observe(event)
+}
+
+// InitTracing starts a span for every request, continuing the trace of the caller if the request
+// carries a W3C traceparent header. Spans are exported by the global tracer provider.
+func (s *Server) InitTracing(serviceName string) {
+	s.Use(otelecho.Middleware(serviceName))
+}
+
+// InitMetrics counts requests, logins and token verifications. The metrics are not served by
+// the server itself, so they can be exposed on a separate listener.
+func (s *Server) InitMetrics(metrics *Metrics) {
+	s.metrics = metrics
+	s.Use(metrics.middleware())
+}
+
+func (s *Server) buildStatusHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reporter, ok := s.repository.(StatusReporter)
+		if !ok {
+			return writeProblem(c, http.StatusNotFound, api.ProblemNotSupported, "the data backend does not report its status")
+		}
+
+		status := reporter.Status()
+		response := api.StatusResponse{
+			Healthy:   status.Healthy(),
+			Revision:  status.Revision,
+			Users:     status.Users,
+			Teams:     status.Teams,
+			LastError: status.LastError,
+		}
+		if !status.LastAttempt.IsZero() {
+			response.LastAttempt = &status.LastAttempt
+		}
+		if !status.LastSuccess.IsZero() {
+			response.LastSuccess = &status.LastSuccess
+		}
+		return c.JSON(http.StatusOK, response)
+	}
+}
+
+func (s *Server) buildChangesHandler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		reporter, ok := s.repository.(ChangeReporter)
+		if !ok {
+			return writeProblem(c, http.StatusNotFound, api.ProblemNotSupported, "the data backend does not report changes")
+		}
+
+		changes := reporter.Changes()
+		if changes == nil {
+			changes = []api.DataChange{}
+		}
+		return c.JSON(http.StatusOK, api.ChangesResponse{Changes: changes})
+	}
+}
+
+// refreshWebhookMaxBody is the largest payload GitHub sends. The signature can only be checked
+// once the body was read, so anyone may send this much.
+const refreshWebhookMaxBody = 25 << 20
+
+// buildRefreshWebhookHandler accepts push notifications signed like GitHub and Gitea do it,
+// with a hex encoded HMAC-SHA256 of the body in the X-Hub-Signature-256 header.
+func (s *Server) buildRefreshWebhookHandler(refresher Refresher, secret []byte) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, refreshWebhookMaxBody))
+		if maxBytesErr := (*http.MaxBytesError)(nil); errors.As(err, &maxBytesErr) {
+			return writeProblem(c, http.StatusRequestEntityTooLarge, api.ProblemInvalidRequest, "")
+		}
+		if err != nil {
+			return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "")
+		}
+
+		signature, found := strings.CutPrefix(c.Request().Header.Get("X-Hub-Signature-256"), "sha256=")
+		expected, err := hex.DecodeString(signature)
+		if !found || err != nil {
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemInvalidSignature, "X-Hub-Signature-256 is missing")
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		if !hmac.Equal(expected, mac.Sum(nil)) {
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemInvalidSignature, "")
+		}
+
+		if err := refresher.Refresh(); err != nil {
+			s.logger(c).Error("refresh from webhook failed", slog.Any("error", err))
+			return writeProblem(c, http.StatusInternalServerError, api.ProblemInternalError, "")
+		}
+
+		return c.NoContent(http.StatusNoContent)
+	}
+}
+
+var placeholderLoginKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public().(ed25519.PublicKey)
+
 func (s *Server) buildLoginHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
-		request := LoginRequest{}
+		request := api.LoginRequest{}
+		failed := func(reason string) {
+			s.recordAudit(c, api.AuditEvent{Action: AuditLogin, Outcome: AuditFailure, Reason: reason, User: request.Username})
+		}
 
 		if err := c.Bind(&request); err != nil {
-			err = fmt.Errorf("login: %w", err)
-			c.Error(err)
-			return c.NoContent(http.StatusBadRequest)
+			failed(AuditReasonInvalidRequest)
+			s.logger(c).Info("invalid login request", slog.Any("error", err))
+			return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "")
 		}
 
+		now := time.Now()
+		if allowed, reason, wait := s.config.LoginLimiter.Allow(c.RealIP(), request.Username, now); !allowed {
+			failed(reason)
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			if reason == AuditReasonLockedOut {
+				return writeProblem(c, http.StatusTooManyRequests, api.ProblemLockedOut, "")
+			}
+			return writeProblem(c, http.StatusTooManyRequests, api.ProblemRateLimited, "")
+		}
+
+		ctx := c.Request().Context()
+		user := attribute.String("teams.user", request.Username)
+
+		span := startSpan(ctx, "DataRepository.GetUserPublicKey", user)
 		key, found := s.repository.GetUserPublicKey(request.Username)
+		span.End()
+		if !found && !s.config.UniformLoginErrors {
+			failed(AuditReasonUnknownUser)
+			s.config.LoginLimiter.Failed(request.Username, now)
+			return writeProblem(c, http.StatusNotFound, api.ProblemUnknownUser, "")
+		}
 		if !found {
-			return c.NoContent(http.StatusNotFound)
+			// verifying against a placeholder key takes as long as for known users
+			key = placeholderLoginKey
 		}
 
-		isValid, err := VerifyChallenge(request.Challenge, request.Username, request.Timestamp, key)
+		span = startSpan(ctx, "api.VerifyChallenge", user)
+		isValid, err := api.VerifyChallenge(request.Challenge, request.Username, request.Timestamp, key)
+		endSpan(span, err)
+		if !found {
+			failed(AuditReasonUnknownUser)
+			s.config.LoginLimiter.Failed(request.Username, now)
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemInvalidSignature, "")
+		}
 		if err != nil || !isValid {
-			return c.NoContent(http.StatusUnauthorized)
+			failed(AuditReasonInvalidSignature)
+			s.config.LoginLimiter.Failed(request.Username, now)
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemInvalidSignature, "")
+		}
+
+		if request.Timestamp.Before(now.Add(-s.config.ChallengeMaxAge)) || request.Timestamp.After(now.Add(s.config.ChallengeMaxAge)) {
+			failed(AuditReasonStaleTimestamp)
+			return writeProblem(c, http.StatusUnauthorized, api.ProblemChallengeExpired, fmt.Sprintf("the timestamp must be within %s of the server time", s.config.ChallengeMaxAge))
 		}
 
-		accessToken, err := s.jwt.Create(request.Username, time.Now())
+		span = startSpan(ctx, "DataRepository.GetUserRoles", user)
+		roles, _ := s.repository.GetUserRoles(request.Username)
+		span.End()
+
+		span = startSpan(ctx, "DataRepository.GetUserTeams", user)
+		teams := s.repository.GetUserTeams(request.Username)
+		span.End()
+
+		span = startSpan(ctx, "JwtHelper.Create", user)
+		accessToken, err := s.jwt.Create(request.Username, roles, teams, now)
+		endSpan(span, err)
 		if err != nil {
-			err = fmt.Errorf("login: %w", err)
-			c.Error(err)
-			return c.NoContent(http.StatusInternalServerError)
+			failed(AuditReasonInternalError)
+			s.logger(c).Error("creating access token failed", slog.Any("error", err))
+			return writeProblem(c, http.StatusInternalServerError, api.ProblemInternalError, "")
 		}
 
-		response := LoginResponse{AccessToken: accessToken}
+		s.config.LoginLimiter.Succeeded(request.Username)
+		s.recordAudit(c, api.AuditEvent{Action: AuditLogin, Outcome: AuditSuccess, User: request.Username})
+		if s.config.Gateway.Cookie != "" {
+			// lets a browser that logged in pass GET /forward-auth and the proxy
+			c.SetCookie(&http.Cookie{
+				Name:     s.config.Gateway.Cookie,
+				Value:    accessToken,
+				Path:     "/",
+				Expires:  now.Add(accessTokenLifetime),
+				Secure:   c.Scheme() == "https",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		response := api.LoginResponse{AccessToken: accessToken}
 		return c.JSON(http.StatusOK, response)
 	}
 }
@@ -70,23 +476,36 @@ func (s *Server) buildVerifyHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		accessToken := c.QueryParam("access_token")
 		if accessToken == "" {
-			return c.NoContent(http.StatusBadRequest)
+			s.recordAudit(c, api.AuditEvent{Action: AuditVerify, Outcome: AuditFailure, Reason: AuditReasonInvalidRequest})
+			return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "access_token is missing")
 		}
 
+		ctx := c.Request().Context()
+
+		span := startSpan(ctx, "JwtHelper.Validate")
 		claims, err := s.jwt.Validate(accessToken)
+		endSpan(span, err)
 		if err != nil {
-			err = fmt.Errorf("verify: %w", err)
-			c.Error(err)
-			return c.NoContent(http.StatusBadRequest)
+			s.recordAudit(c, api.AuditEvent{Action: AuditVerify, Outcome: AuditFailure, Reason: AuditReasonInvalidToken})
+			s.logger(c).Info("invalid access token", slog.Any("error", err))
+			return writeProblem(c, http.StatusBadRequest, tokenProblem(err), "")
 		}
 
 		username := claims.Subject
+		span = startSpan(ctx, "DataRepository.UserExists", attribute.String("teams.user", username))
 		found := s.repository.UserExists(username)
+		span.End()
 		if !found {
-			return c.NoContent(http.StatusNotFound)
+			s.recordAudit(c, api.AuditEvent{Action: AuditVerify, Outcome: AuditFailure, Reason: AuditReasonUnknownUser, User: username})
+			return writeProblem(c, http.StatusNotFound, api.ProblemUnknownUser, "the owner of the token no longer exists")
 		}
 
-		response := VerifyResponse{Username: username}
+		span = startSpan(ctx, "DataRepository.GetUserTeams", attribute.String("teams.user", username))
+		teams := s.repository.GetUserTeams(username)
+		span.End()
+
+		s.recordAudit(c, api.AuditEvent{Action: AuditVerify, Outcome: AuditSuccess, User: username})
+		response := api.VerifyResponse{Username: username, Teams: teams}
 		return c.JSON(http.StatusOK, response)
 	}
 }
@@ -95,31 +514,162 @@ func (s *Server) buildTeamHandler() echo.HandlerFunc {
 	return func(c echo.Context) error {
 		teamID := c.Param("id")
 
+		span := startSpan(c.Request().Context(), "DataRepository.GetTeamMembers", attribute.String("teams.team", teamID))
 		members, found := s.repository.GetTeamMembers(teamID)
+		span.End()
 		if !found {
-			return c.NoContent(http.StatusNotFound)
+			s.recordAudit(c, api.AuditEvent{Action: AuditTeamLookup, Outcome: AuditFailure, Reason: AuditReasonUnknownTeam, Team: teamID})
+			return writeProblem(c, http.StatusNotFound, api.ProblemTeamNotFound, "")
 		}
 
-		response := TeamResponse{TeamID: teamID, Members: members}
+		s.recordAudit(c, api.AuditEvent{Action: AuditTeamLookup, Outcome: AuditSuccess, Team: teamID})
+
+		response := api.TeamResponse{TeamID: teamID, Members: members}
 		return c.JSON(http.StatusOK, response)
 	}
 }
 
-type LoginRequest struct {
-	Username  string    `json:"username"`
-	Timestamp time.Time `json:"timestamp"`
-	Challenge string    `json:"challenge"`
+func (s *Server) buildPutTeamHandler(repository WritableDataRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		teamID := c.Param("id")
+
+		request := api.TeamRequest{}
+		if err := c.Bind(&request); err != nil {
+			s.logger(c).Info("invalid team request", slog.Any("error", err))
+			return writeProblem(c, http.StatusBadRequest, api.ProblemInvalidRequest, "")
+		}
+
+		span := startSpan(c.Request().Context(), "DataRepository.PutTeam", attribute.String("teams.team", c.Param("id")))
+		err := repository.PutTeam(teamID, request.Members)
+		endSpan(span, err)
+		s.recordWrite(c, api.AuditEvent{Action: AuditTeamPut, Team: teamID}, err)
+		return s.respondToWrite(c, err)
+	}
 }
 
-type LoginResponse struct {
-	AccessToken string `json:"access_token"`
+func (s *Server) buildDeleteTeamHandler(repository WritableDataRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		span := startSpan(c.Request().Context(), "DataRepository.DeleteTeam", attribute.String("teams.team", c.Param("id")))
+		err := repository.DeleteTeam(c.Param("id"))
+		endSpan(span, err)
+		s.recordWrite(c, api.AuditEvent{Action: AuditTeamDelete, Team: c.Param("id")}, err)
+		return s.respondToWrite(c, err)
+	}
 }
 
-type TeamResponse struct {
-	TeamID  string   `json:"team_id"`
-	Members []string `json:"member"`
+func (s *Server) buildAddTeamMemberHandler(repository WritableDataRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		span := startSpan(c.Request().Context(), "DataRepository.AddTeamMember", attribute.String("teams.team", c.Param("id")))
+		err := repository.AddTeamMember(c.Param("id"), c.Param("username"))
+		endSpan(span, err)
+		s.recordWrite(c, api.AuditEvent{Action: AuditMemberAdd, Team: c.Param("id"), Member: c.Param("username")}, err)
+		return s.respondToWrite(c, err)
+	}
+}
+
+func (s *Server) buildRemoveTeamMemberHandler(repository WritableDataRepository) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		span := startSpan(c.Request().Context(), "DataRepository.RemoveTeamMember", attribute.String("teams.team", c.Param("id")))
+		err := repository.RemoveTeamMember(c.Param("id"), c.Param("username"))
+		endSpan(span, err)
+		s.recordWrite(c, api.AuditEvent{Action: AuditMemberRemove, Team: c.Param("id"), Member: c.Param("username")}, err)
+		return s.respondToWrite(c, err)
+	}
 }
 
-type VerifyResponse struct {
-	Username string `json:"username"`
+func (s *Server) recordWrite(c echo.Context, event api.AuditEvent, err error) {
+	event.User, _ = c.Get(usernameContextKey).(string)
+	event.Outcome = AuditFailure
+	switch {
+	case err == nil:
+		event.Outcome = AuditSuccess
+	case errors.Is(err, api.ErrUnknownTeam):
+		event.Reason = AuditReasonUnknownTeam
+	case errors.Is(err, api.ErrUnknownUser):
+		event.Reason = AuditReasonUnknownUser
+	case errors.Is(err, api.ErrEmptyTeam):
+		event.Reason = AuditReasonEmptyTeam
+	default:
+		event.Reason = AuditReasonInternalError
+	}
+	s.recordAudit(c, event)
+}
+
+func (s *Server) respondToWrite(c echo.Context, err error) error {
+	switch {
+	case err == nil:
+		return c.NoContent(http.StatusNoContent)
+	case errors.Is(err, api.ErrUnknownTeam):
+		return writeProblem(c, http.StatusNotFound, api.ProblemTeamNotFound, "")
+	case errors.Is(err, api.ErrUnknownUser):
+		return writeProblem(c, http.StatusUnprocessableEntity, api.ProblemUnknownUser, "")
+	case errors.Is(err, api.ErrEmptyTeam):
+		return writeProblem(c, http.StatusConflict, api.ProblemEmptyTeam, "")
+	default:
+		s.logger(c).Error("data write failed", slog.Any("error", err))
+		return writeProblem(c, http.StatusInternalServerError, api.ProblemInternalError, "")
+	}
+}
+
+const (
+	rolesContextKey    = "roles"
+	usernameContextKey = "username"
+)
+
+func (s *Server) buildAuthenticationMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			accessToken, found := strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+			if !found || accessToken == "" {
+				return writeProblem(c, http.StatusUnauthorized, api.ProblemMissingToken, "")
+			}
+
+			span := startSpan(c.Request().Context(), "JwtHelper.Validate")
+			claims, err := s.jwt.Validate(accessToken)
+			endSpan(span, err)
+			if err != nil {
+				return writeProblem(c, http.StatusUnauthorized, tokenProblem(err), "")
+			}
+
+			// the roles of the token are those at login. Authorization uses the current roles, so
+			// revoked roles and removed users lose access before their tokens expire.
+			span = startSpan(c.Request().Context(), "DataRepository.GetUserRoles", attribute.String("teams.user", claims.Subject))
+			roles, _ := s.repository.GetUserRoles(claims.Subject)
+			span.End()
+
+			c.Set(rolesContextKey, roles)
+			c.Set(usernameContextKey, claims.Subject)
+			return next(c)
+		}
+	}
+}
+
+type accessPolicy func(c echo.Context, roles []Role) bool
+
+func isAdmin(_ echo.Context, roles []Role) bool {
+	return IsAdmin(roles)
+}
+
+func canEditTeam(c echo.Context, roles []Role) bool {
+	return CanEditTeam(roles, c.Param("id"))
+}
+
+// canPutTeam lets maintainers replace the members of their team, while only admins create teams.
+func (s *Server) canPutTeam(c echo.Context, roles []Role) bool {
+	if _, found := s.repository.GetTeamMembers(c.Param("id")); found {
+		return CanEditTeam(roles, c.Param("id"))
+	}
+	return IsAdmin(roles)
+}
+
+func buildAuthorizationMiddleware(policy accessPolicy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			roles, _ := c.Get(rolesContextKey).([]Role)
+			if !policy(c, roles) {
+				return writeProblem(c, http.StatusForbidden, api.ProblemForbidden, "")
+			}
+			return next(c)
+		}
+	}
 }
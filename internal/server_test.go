@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the repository with all routes. The returned helper issues tokens that
+// the server accepts.
+func newTestServer(t *testing.T, config ServerConfig, repository DataRepository) (*Server, *JwtHelper) {
+	t.Helper()
+
+	jwt := NewJwtHelper(JwtHelperConfig{Issuer: "test", Audience: "test", Secret: []byte("secret")})
+	server := NewServer(config, jwt, repository)
+	server.InitRoutes()
+	return server, jwt
+}
+
+func newTestKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	return public, private
+}
+
+func TestServerLogsRequestID(t *testing.T) {
+	buffer := bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(&buffer, nil))
+
+	server, _ := newTestServer(t, ServerConfig{Logger: logger}, NewSnapshotDataRepository(staticSnapshot{}))
+
+	request := httptest.NewRequest(http.MethodGet, "/teams/unknown", nil)
+	request.Header.Set("X-Request-ID", "request-1")
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+
+	if id := recorder.Header().Get("X-Request-ID"); id != "request-1" {
+		t.Errorf("expected request id to be echoed, got %q", id)
+	}
+
+	entry := struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+		Status    int    `json:"status"`
+	}{}
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log entry %q: %v", buffer.String(), err)
+	}
+	if entry.Msg != "request" || entry.RequestID != "request-1" || entry.Status != http.StatusNotFound {
+		t.Errorf("unexpected log entry %+v", entry)
+	}
+
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if recorder.Header().Get("X-Request-ID") == "" {
+		t.Error("expected a request id to be generated")
+	}
+}
+
+func TestServerReadiness(t *testing.T) {
+	server, _ := newTestServer(t, ServerConfig{}, NewSnapshotDataRepository(staticSnapshot{}))
+
+	probe := func(target string) int {
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+		return recorder.Code
+	}
+
+	if probe("/readyz") != http.StatusOK || probe("/livez") != http.StatusOK {
+		t.Fatal("expected server to be ready and live")
+	}
+
+	server.BeginShutdown()
+
+	if code := probe("/readyz"); code != http.StatusServiceUnavailable {
+		t.Errorf("expected server to stop being ready, got %d", code)
+	}
+	if code := probe("/livez"); code != http.StatusOK {
+		t.Errorf("expected server to stay live during shutdown, got %d", code)
+	}
+}
+
+func TestServerAuthorizesWrites(t *testing.T) {
+	repository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer repository.Close()
+
+	err = repository.Import(DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"admin": {1}, "maintainer": {2}, "reader": {3}, "dave": {4}},
+		Roles: map[string][]Role{
+			"admin":      {{Name: RoleAdmin}},
+			"maintainer": {{Name: RoleTeamMaintainer, Team: "team-1"}},
+			"reader":     {{Name: RoleReader}},
+		},
+		Teams: map[string][]string{"team-1": {"maintainer", "dave"}, "team-2": {"dave"}},
+	})
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	server, jwt := newTestServer(t, ServerConfig{}, repository)
+	server.InitAudit(NewAuditLog())
+
+	tokens := map[string]string{}
+	for username, roles := range map[string][]Role{"admin": {{Name: RoleAdmin}}, "maintainer": {{Name: RoleTeamMaintainer, Team: "team-1"}}, "reader": {{Name: RoleReader}}} {
+		tokens[username], _ = jwt.Create(username, roles, nil, time.Now())
+	}
+	// dave was an admin at login
+	tokens["dave"], _ = jwt.Create("dave", []Role{{Name: RoleAdmin}}, nil, time.Now())
+
+	requests := []struct {
+		user   string
+		method string
+		path   string
+		body   any
+		status int
+	}{
+		{"reader", http.MethodPut, "/teams/team-1", api.TeamRequest{Members: []string{"dave"}}, http.StatusForbidden},
+		{"reader", http.MethodPut, "/teams/team-1/members/reader", nil, http.StatusForbidden},
+		{"reader", http.MethodDelete, "/teams/team-1", nil, http.StatusForbidden},
+		{"maintainer", http.MethodPut, "/teams/team-2", api.TeamRequest{Members: []string{"dave"}}, http.StatusForbidden},
+		{"maintainer", http.MethodPut, "/teams/team-3", api.TeamRequest{Members: []string{"maintainer"}}, http.StatusForbidden},
+		{"maintainer", http.MethodDelete, "/teams/team-1", nil, http.StatusForbidden},
+		{"maintainer", http.MethodPut, "/teams/team-2/members/reader", nil, http.StatusForbidden},
+		{"maintainer", http.MethodPut, "/teams/team-1", api.TeamRequest{Members: []string{"maintainer", "dave", "reader"}}, http.StatusNoContent},
+		{"maintainer", http.MethodDelete, "/teams/team-1/members/reader", nil, http.StatusNoContent},
+		{"dave", http.MethodPut, "/teams/team-3", api.TeamRequest{Members: []string{"dave"}}, http.StatusForbidden},
+		{"dave", http.MethodDelete, "/teams/team-2", nil, http.StatusForbidden},
+		{"admin", http.MethodPut, "/teams/team-3", api.TeamRequest{Members: []string{"dave"}}, http.StatusNoContent},
+		{"admin", http.MethodDelete, "/teams/team-3", nil, http.StatusNoContent},
+		{"maintainer", http.MethodGet, "/audit", nil, http.StatusForbidden},
+		{"reader", http.MethodGet, "/audit", nil, http.StatusForbidden},
+		// the audit log has no file to query, which is only reported once the role is accepted
+		{"admin", http.MethodGet, "/audit", nil, http.StatusNotFound},
+	}
+	for _, r := range requests {
+		body := []byte(nil)
+		if r.body != nil {
+			body, _ = json.Marshal(r.body)
+		}
+		request := httptest.NewRequest(r.method, r.path, bytes.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+tokens[r.user])
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+
+		if recorder.Code != r.status {
+			t.Errorf("%s %s %s: expected %d, got %d %s", r.user, r.method, r.path, r.status, recorder.Code, recorder.Body)
+		}
+	}
+}
+
+func TestLoginRejectsChallengesOutsideMaxAge(t *testing.T) {
+	public, private := newTestKey(t)
+	server, _ := newTestServer(t, ServerConfig{ChallengeMaxAge: 2 * time.Minute}, NewSnapshotDataRepository(staticSnapshot{Users: map[string]ed25519.PublicKey{"alice": public}}))
+
+	now := time.Now()
+	logins := []struct {
+		timestamp time.Time
+		status    int
+		code      string
+	}{
+		{now.Add(-time.Minute), http.StatusOK, ""},
+		{now.Add(time.Minute), http.StatusOK, ""},
+		{now.Add(-3 * time.Minute), http.StatusUnauthorized, api.ProblemChallengeExpired},
+		{now.Add(3 * time.Minute), http.StatusUnauthorized, api.ProblemChallengeExpired},
+	}
+	for _, l := range logins {
+		body, _ := json.Marshal(api.LoginRequest{Username: "alice", Timestamp: l.timestamp, Challenge: api.CreateChallenge("alice", l.timestamp, private)})
+		request := httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, request)
+
+		problem := api.Problem{}
+		_ = json.Unmarshal(recorder.Body.Bytes(), &problem)
+		if recorder.Code != l.status || problem.Code != l.code {
+			t.Errorf("login %s from now: expected %d %s, got %d %s", l.timestamp.Sub(now), l.status, l.code, recorder.Code, problem.Code)
+		}
+	}
+}
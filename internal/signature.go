@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+const SignatureSuffix = ".sig"
+
+var ErrUntrustedSignature = errors.New("data file is not signed by a trusted key")
+
+func SignData(content []byte, key ed25519.PrivateKey) []byte {
+	signature := ed25519.Sign(key, content)
+	return []byte(base64.StdEncoding.EncodeToString(signature) + "\n")
+}
+
+func VerifyDataSignature(content []byte, signature []byte, trustedKeys []ed25519.PublicKey) error {
+	blob, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil {
+		return fmt.Errorf("verify data file signature: %w", err)
+	}
+
+	for _, key := range trustedKeys {
+		if ed25519.Verify(key, content, blob) {
+			return nil
+		}
+	}
+	return ErrUntrustedSignature
+}
+
+func ParsePublicKeys(values []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(values))
+	for _, value := range values {
+		blob, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(blob) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q", value)
+		}
+		keys = append(keys, blob)
+	}
+	return keys, nil
+}
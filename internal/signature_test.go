@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+func TestVerifyDataSignature(t *testing.T) {
+	trustedPub, trustedPriv, _ := ed25519.GenerateKey(rand.Reader)
+	otherPub, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	content := []byte("teams:\n  team-1: [alice]\n")
+	signature := SignData(content, trustedPriv)
+
+	if err := VerifyDataSignature(content, signature, []ed25519.PublicKey{otherPub, trustedPub}); err != nil {
+		t.Fatalf("expected signature to verify: %v", err)
+	}
+
+	t.Run("modified content", func(t *testing.T) {
+		modified := append(content, []byte("  team-2: [mallory]\n")...)
+		err := VerifyDataSignature(modified, signature, []ed25519.PublicKey{trustedPub})
+		if !errors.Is(err, ErrUntrustedSignature) {
+			t.Errorf("expected untrusted signature, got %v", err)
+		}
+	})
+
+	t.Run("untrusted signer", func(t *testing.T) {
+		err := VerifyDataSignature(content, SignData(content, otherPriv), []ed25519.PublicKey{trustedPub})
+		if !errors.Is(err, ErrUntrustedSignature) {
+			t.Errorf("expected untrusted signature, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,366 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"slices"
+)
+
+type SQLDataRepository struct {
+	db *sql.DB
+}
+
+func NewSQLDataRepository(db *sql.DB) *SQLDataRepository {
+	return &SQLDataRepository{db: db}
+}
+
+func (r *SQLDataRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *SQLDataRepository) UserExists(username string) bool {
+	_, found := r.GetUserPublicKey(username)
+	return found
+}
+
+func (r *SQLDataRepository) GetUserPublicKey(username string) (ed25519.PublicKey, bool) {
+	var key []byte
+	err := r.db.QueryRow(`SELECT key FROM users WHERE name = $1`, username).Scan(&key)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("get user public key", slog.String("user", username), slog.Any("error", err))
+		}
+		return nil, false
+	}
+	return key, true
+}
+
+func (r *SQLDataRepository) GetUserRoles(username string) ([]Role, bool) {
+	if !r.UserExists(username) {
+		return nil, false
+	}
+
+	values, err := queryStrings(r.db, `SELECT role FROM user_roles WHERE username = $1 ORDER BY role`, username)
+	if err != nil {
+		slog.Error("get user roles", slog.String("user", username), slog.Any("error", err))
+		return nil, false
+	}
+
+	roles, err := ParseRoles(values)
+	if err != nil {
+		slog.Error("get user roles", slog.String("user", username), slog.Any("error", err))
+		return nil, false
+	}
+	return roles, true
+}
+
+func (r *SQLDataRepository) GetTeamMembers(team string) ([]string, bool) {
+	exists, err := rowExists(r.db, `SELECT 1 FROM teams WHERE name = $1`, team)
+	if err != nil || !exists {
+		if err != nil {
+			slog.Error("get team members", slog.String("team", team), slog.Any("error", err))
+		}
+		return nil, false
+	}
+
+	members, err := queryStrings(r.db, `SELECT username FROM team_members WHERE team = $1 ORDER BY id`, team)
+	if err != nil {
+		slog.Error("get team members", slog.String("team", team), slog.Any("error", err))
+		return nil, false
+	}
+	return members, true
+}
+
+func (r *SQLDataRepository) GetUserTeams(username string) []string {
+	teams, err := queryStrings(r.db, `SELECT team FROM team_members WHERE username = $1 ORDER BY team`, username)
+	if err != nil {
+		slog.Error("get user teams", slog.String("user", username), slog.Any("error", err))
+		return nil
+	}
+	return teams
+}
+
+func (r *SQLDataRepository) PutTeam(team string, members []string) error {
+	if len(members) == 0 {
+		return api.ErrEmptyTeam
+	}
+
+	return r.transaction(func(tx *sql.Tx) error {
+		for _, username := range members {
+			if err := requireUser(tx, username); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(`INSERT INTO teams (name) VALUES ($1) ON CONFLICT DO NOTHING`, team); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM team_members WHERE team = $1`, team); err != nil {
+			return err
+		}
+		for _, username := range members {
+			_, err := tx.Exec(`INSERT INTO team_members (team, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, team, username)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *SQLDataRepository) DeleteTeam(team string) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM teams WHERE name = $1`, team)
+		if err != nil {
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return api.ErrUnknownTeam
+		}
+		return nil
+	})
+}
+
+func (r *SQLDataRepository) AddTeamMember(team string, username string) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		if err := requireTeam(tx, team); err != nil {
+			return err
+		}
+		if err := requireUser(tx, username); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec(`INSERT INTO team_members (team, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, team, username)
+		return err
+	})
+}
+
+func (r *SQLDataRepository) RemoveTeamMember(team string, username string) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		if err := requireTeam(tx, team); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`DELETE FROM team_members WHERE team = $1 AND username = $2`, team, username); err != nil {
+			return err
+		}
+
+		remaining, err := rowExists(tx, `SELECT 1 FROM team_members WHERE team = $1`, team)
+		if err != nil {
+			return err
+		}
+		if !remaining {
+			return api.ErrEmptyTeam
+		}
+		return nil
+	})
+}
+
+func (r *SQLDataRepository) Import(snapshot DataSnapshot) error {
+	return r.transaction(func(tx *sql.Tx) error {
+		for _, table := range []string{"team_members", "teams", "user_roles", "users"} {
+			if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+				return err
+			}
+		}
+
+		usernames := make([]string, 0, len(snapshot.Users))
+		for username := range snapshot.Users {
+			usernames = append(usernames, username)
+		}
+		slices.Sort(usernames)
+
+		for _, username := range usernames {
+			key := []byte(snapshot.Users[username])
+			if _, err := tx.Exec(`INSERT INTO users (name, key) VALUES ($1, $2)`, username, key); err != nil {
+				return err
+			}
+			for _, role := range snapshot.Roles[username] {
+				_, err := tx.Exec(`INSERT INTO user_roles (username, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`, username, role.String())
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for team, members := range snapshot.Teams {
+			if _, err := tx.Exec(`INSERT INTO teams (name) VALUES ($1)`, team); err != nil {
+				return err
+			}
+			for _, username := range members {
+				_, err := tx.Exec(`INSERT INTO team_members (team, username) VALUES ($1, $2) ON CONFLICT DO NOTHING`, team, username)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (r *SQLDataRepository) Snapshot() (DataSnapshot, error) {
+	snapshot := DataSnapshot{
+		Users: make(map[string]ed25519.PublicKey),
+		Roles: make(map[string][]Role),
+		Teams: make(map[string][]string),
+	}
+
+	options := &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+	tx, err := r.db.BeginTx(context.Background(), options)
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = scanRows(tx, `SELECT name, key FROM users`, func(rows *sql.Rows) error {
+		var name string
+		var key []byte
+		if err := rows.Scan(&name, &key); err != nil {
+			return err
+		}
+		snapshot.Users[name] = key
+		return nil
+	})
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	err = scanRows(tx, `SELECT username, role FROM user_roles ORDER BY username, role`, func(rows *sql.Rows) error {
+		var username, value string
+		if err := rows.Scan(&username, &value); err != nil {
+			return err
+		}
+		role, err := ParseRole(value)
+		if err != nil {
+			return err
+		}
+		snapshot.Roles[username] = append(snapshot.Roles[username], role)
+		return nil
+	})
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	err = scanRows(tx, `SELECT name FROM teams`, func(rows *sql.Rows) error {
+		var team string
+		if err := rows.Scan(&team); err != nil {
+			return err
+		}
+		snapshot.Teams[team] = []string{}
+		return nil
+	})
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	err = scanRows(tx, `SELECT team, username FROM team_members ORDER BY id`, func(rows *sql.Rows) error {
+		var team, username string
+		if err := rows.Scan(&team, &username); err != nil {
+			return err
+		}
+		snapshot.Teams[team] = append(snapshot.Teams[team], username)
+		return nil
+	})
+	if err != nil {
+		return DataSnapshot{}, fmt.Errorf("load snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+func (r *SQLDataRepository) transaction(operation func(tx *sql.Tx) error) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := operation(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func queryStrings(q queryer, query string, args ...any) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+func scanRows(q queryer, query string, scan func(rows *sql.Rows) error) error {
+	rows, err := q.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func rowExists(q queryer, query string, args ...any) (bool, error) {
+	var one int
+	err := q.QueryRow(query, args...).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func requireUser(q queryer, username string) error {
+	exists, err := rowExists(q, `SELECT 1 FROM users WHERE name = $1`, username)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return api.ErrUnknownUser
+	}
+	return nil
+}
+
+func requireTeam(q queryer, team string) error {
+	exists, err := rowExists(q, `SELECT 1 FROM teams WHERE name = $1`, team)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return api.ErrUnknownTeam
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"sync"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+// SQLiteDataRepository publishes the changes of its writes to subscribers. Changes made by other
+// processes, like `teams-server import`, are published along with the next write. Its status
+// reports the snapshot taken after the last write.
+type SQLiteDataRepository struct {
+	*SQLDataRepository
+	reloadTracker
+	changeTracker
+	// writes keeps snapshots in the order of the writes they follow
+	writes sync.Mutex
+}
+
+func NewSQLiteDataRepository(path string) (*SQLiteDataRepository, error) {
+	query := url.Values{}
+	query.Add("_pragma", "foreign_keys(1)")
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "busy_timeout(5000)")
+	query.Add("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite", "file:"+path+"?"+query.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+
+	migrations, err := fs.Sub(sqliteMigrations, "migrations/sqlite")
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	if err := migrateDatabase(db, migrations, "", ""); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+
+	r := &SQLiteDataRepository{SQLDataRepository: NewSQLDataRepository(db)}
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+	r.succeeded(snapshot)
+	r.track(snapshot)
+	return r, nil
+}
+
+func (r *SQLiteDataRepository) PutTeam(team string, members []string) error {
+	return r.write(func() error { return r.SQLDataRepository.PutTeam(team, members) })
+}
+
+func (r *SQLiteDataRepository) DeleteTeam(team string) error {
+	return r.write(func() error { return r.SQLDataRepository.DeleteTeam(team) })
+}
+
+func (r *SQLiteDataRepository) AddTeamMember(team string, username string) error {
+	return r.write(func() error { return r.SQLDataRepository.AddTeamMember(team, username) })
+}
+
+func (r *SQLiteDataRepository) RemoveTeamMember(team string, username string) error {
+	return r.write(func() error { return r.SQLDataRepository.RemoveTeamMember(team, username) })
+}
+
+func (r *SQLiteDataRepository) Import(snapshot DataSnapshot) error {
+	return r.write(func() error { return r.SQLDataRepository.Import(snapshot) })
+}
+
+// write compares the database with the previous write once the operation succeeded. A failed
+// comparison does not fail the write, its changes are published with the next one.
+func (r *SQLiteDataRepository) write(operation func() error) error {
+	r.writes.Lock()
+	defer r.writes.Unlock()
+
+	if err := operation(); err != nil {
+		return err
+	}
+
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		r.failed(err)
+		slog.Error("sqlite snapshot after write failed", slog.Any("error", err))
+		return nil
+	}
+	r.succeeded(snapshot)
+	r.track(snapshot)
+	return nil
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/pscheid/teams/api"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDataRepository(t *testing.T) {
+	repository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer repository.Close()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	snapshot := DataSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": pub, "bob": pub},
+		Roles: map[string][]Role{"alice": {{Name: RoleTeamMaintainer, Team: "team-1"}}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	}
+	if err := repository.Import(snapshot); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	key, found := repository.GetUserPublicKey("alice")
+	if !found || !key.Equal(pub) {
+		t.Fatal("expected imported public key")
+	}
+	if repository.UserExists("carol") {
+		t.Error("expected unknown user not to exist")
+	}
+
+	roles, found := repository.GetUserRoles("alice")
+	if !found || !slices.Equal(roles, snapshot.Roles["alice"]) {
+		t.Errorf("expected imported roles, got %v", roles)
+	}
+
+	if err := repository.AddTeamMember("team-1", "bob"); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+	members, _ := repository.GetTeamMembers("team-1")
+	if !slices.Equal(members, []string{"alice", "bob"}) {
+		t.Errorf("expected members in insertion order, got %v", members)
+	}
+
+	if err := repository.AddTeamMember("team-1", "carol"); !errors.Is(err, api.ErrUnknownUser) {
+		t.Errorf("expected unknown user error, got %v", err)
+	}
+	if err := repository.AddTeamMember("team-2", "bob"); !errors.Is(err, api.ErrUnknownTeam) {
+		t.Errorf("expected unknown team error, got %v", err)
+	}
+
+	if err := repository.PutTeam("team-2", []string{"bob", "bob"}); err != nil {
+		t.Fatalf("put team: %v", err)
+	}
+	if err := repository.RemoveTeamMember("team-2", "bob"); !errors.Is(err, api.ErrEmptyTeam) {
+		t.Errorf("expected empty team error, got %v", err)
+	}
+	members, _ = repository.GetTeamMembers("team-2")
+	if !slices.Equal(members, []string{"bob"}) {
+		t.Errorf("expected rejected removal to be rolled back, got %v", members)
+	}
+
+	if err := repository.DeleteTeam("team-2"); err != nil {
+		t.Fatalf("delete team: %v", err)
+	}
+	if _, found := repository.GetTeamMembers("team-2"); found {
+		t.Error("expected deleted team to be gone")
+	}
+}
+
+func TestSQLiteDataRepositoryPublishesWrites(t *testing.T) {
+	repository, err := NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	defer repository.Close()
+
+	if err := repository.Import(DataSnapshot{Users: map[string]ed25519.PublicKey{"alice": {1}, "bob": {2}}}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	changes, unsubscribe := repository.Subscribe()
+	defer unsubscribe()
+
+	if err := repository.PutTeam("team-1", []string{"alice"}); err != nil {
+		t.Fatalf("put team: %v", err)
+	}
+	if err := repository.AddTeamMember("team-1", "bob"); err != nil {
+		t.Fatalf("add member: %v", err)
+	}
+
+	for _, expected := range []string{"alice", "bob"} {
+		select {
+		case change := <-changes:
+			if !slices.Equal(change.MembersAdded["team-1"], []string{expected}) {
+				t.Errorf("expected %s to join team-1, got %+v", expected, change)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a change for %s", expected)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+type ReloadStatus struct {
+	LastAttempt time.Time
+	LastSuccess time.Time
+	LastError   string
+	Revision    string
+	Users       int
+	Teams       int
+	// Successes and Failures count the reloads since the start of the process.
+	Successes uint64
+	Failures  uint64
+}
+
+// Healthy reports whether the most recent reload succeeded. The current snapshot is still
+// served otherwise, but it no longer reflects the source.
+func (s ReloadStatus) Healthy() bool {
+	return s.LastError == ""
+}
+
+type StatusReporter interface {
+	Status() ReloadStatus
+}
+
+type reloadTracker struct {
+	mutex  sync.Mutex
+	status ReloadStatus
+}
+
+func (t *reloadTracker) succeeded(snapshot DataSnapshot) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	t.status = ReloadStatus{
+		LastAttempt: now,
+		LastSuccess: now,
+		Revision:    snapshot.Revision,
+		Users:       len(snapshot.Users),
+		Teams:       len(snapshot.Teams),
+		Successes:   t.status.Successes + 1,
+		Failures:    t.status.Failures,
+	}
+}
+
+func (t *reloadTracker) failed(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.status.LastAttempt = time.Now()
+	t.status.LastError = err.Error()
+	t.status.Failures++
+}
+
+func (t *reloadTracker) Status() ReloadStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.status
+}
@@ -0,0 +1,32 @@
+// Package testserver starts teams-server for the tests of packages that talk to it.
+package testserver
+
+import (
+	"github.com/pscheid/teams/internal"
+	"path/filepath"
+	"testing"
+)
+
+// Config is the jwt configuration of the server. Tokens created with it are accepted.
+var Config = internal.JwtHelperConfig{Issuer: "test", Audience: "test", Secret: []byte("secret")}
+
+// New serves the snapshot from a writable repository with all routes. The returned helper issues
+// tokens that the server accepts.
+func New(t *testing.T, snapshot internal.DataSnapshot) (*internal.Server, *internal.JwtHelper) {
+	t.Helper()
+
+	repository, err := internal.NewSQLiteDataRepository(filepath.Join(t.TempDir(), "teams.db"))
+	if err != nil {
+		t.Fatalf("open repository: %v", err)
+	}
+	t.Cleanup(func() { _ = repository.Close() })
+
+	if err := repository.Import(snapshot); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	jwt := internal.NewJwtHelper(Config)
+	server := internal.NewServer(internal.ServerConfig{}, jwt, repository)
+	server.InitRoutes()
+	return server, jwt
+}
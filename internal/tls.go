@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+type TLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables client certificates, which are verified against the CAs in the file.
+	ClientCAFile string
+	// ReloadInterval is how often the certificate files are checked for rotation.
+	ReloadInterval time.Duration
+}
+
+// CertificateReloader serves the certificate in CertFile and KeyFile and picks up rotated files,
+// so renewed certificates are used without a restart. A broken rotation keeps the previous
+// certificate in use.
+type CertificateReloader struct {
+	config      TLSConfig
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	content     []byte
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+func NewCertificateReloader(config TLSConfig) (*CertificateReloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("tls: certificate and key file are required")
+	}
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = time.Minute
+	}
+
+	r := &CertificateReloader{
+		config: config,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	go r.monitor()
+	return r, nil
+}
+
+func (r *CertificateReloader) monitor() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				slog.Error("reloading tls certificate failed", slog.Any("error", err))
+			} else if reloaded {
+				slog.Info("reloaded tls certificate", slog.String("file", r.config.CertFile))
+			}
+		}
+	}
+}
+
+// Reload reads the certificate files and reports whether they changed since the last call.
+func (r *CertificateReloader) Reload() (bool, error) {
+	certPEM, err := os.ReadFile(r.config.CertFile)
+	if err != nil {
+		return false, fmt.Errorf("tls: %w", err)
+	}
+	keyPEM, err := os.ReadFile(r.config.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("tls: %w", err)
+	}
+
+	content := append(certPEM, keyPEM...)
+
+	r.mutex.RLock()
+	unchanged := bytes.Equal(content, r.content)
+	r.mutex.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, fmt.Errorf("tls: %w", err)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = &certificate
+	r.content = content
+	return true, nil
+}
+
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+// ServerConfig builds the TLS configuration of the server. Client certificates are requested, but
+// only required by the routes that the server is configured to protect with them.
+func (r *CertificateReloader) ServerConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+
+	if r.config.ClientCAFile != "" {
+		pool, err := loadCertPool(r.config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config, nil
+}
+
+func (r *CertificateReloader) Close() error {
+	close(r.stop)
+	<-r.done
+	return nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("tls: no certificates found in %s", path)
+	}
+	return pool, nil
+}
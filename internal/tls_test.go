@@ -0,0 +1,182 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	certPEM     string
+	keyPEM      string
+}
+
+// issueTestCertificate creates a certificate signed by issuer, or a self-signed CA if issuer is nil.
+func issueTestCertificate(t *testing.T, name string, issuer *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.certificate, issuer.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		certPEM:     string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:      string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	config := TLSConfig{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key"), ReloadInterval: time.Hour}
+
+	ca := issueTestCertificate(t, "ca", nil)
+	first := issueTestCertificate(t, "first", ca)
+	writeTestFile(t, config.CertFile, first.certPEM)
+	writeTestFile(t, config.KeyFile, first.keyPEM)
+
+	reloader, err := NewCertificateReloader(config)
+	if err != nil {
+		t.Fatalf("create reloader: %v", err)
+	}
+	defer reloader.Close()
+
+	served := func() string {
+		certificate, _ := reloader.GetCertificate(nil)
+		parsed, _ := x509.ParseCertificate(certificate.Certificate[0])
+		return parsed.Subject.CommonName
+	}
+
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Errorf("expected unchanged files to be skipped, got %v, %v", reloaded, err)
+	}
+
+	second := issueTestCertificate(t, "second", ca)
+	writeTestFile(t, config.CertFile, second.certPEM)
+	writeTestFile(t, config.KeyFile, second.keyPEM)
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected rotated certificate to be loaded, got %v, %v", reloaded, err)
+	}
+	if name := served(); name != "second" {
+		t.Errorf("expected rotated certificate to be served, got %s", name)
+	}
+
+	// a half written rotation keeps the previous certificate
+	writeTestFile(t, config.KeyFile, first.keyPEM)
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("expected mismatching key to be rejected")
+	}
+	if name := served(); name != "second" {
+		t.Errorf("expected previous certificate to be kept, got %s", name)
+	}
+}
+
+func TestClientCertificateRoutes(t *testing.T) {
+	dir := t.TempDir()
+	ca := issueTestCertificate(t, "ca", nil)
+	serverCertificate := issueTestCertificate(t, "server", ca)
+	clientCertificate := issueTestCertificate(t, "client", ca)
+
+	files := map[string]string{
+		"ca.crt": ca.certPEM, "server.crt": serverCertificate.certPEM, "server.key": serverCertificate.keyPEM,
+		"client.crt": clientCertificate.certPEM, "client.key": clientCertificate.keyPEM,
+	}
+	for name, content := range files {
+		writeTestFile(t, filepath.Join(dir, name), content)
+	}
+
+	reloader, err := NewCertificateReloader(TLSConfig{
+		CertFile:     filepath.Join(dir, "server.crt"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	})
+	if err != nil {
+		t.Fatalf("create reloader: %v", err)
+	}
+	defer reloader.Close()
+
+	tlsConfig, err := reloader.ServerConfig()
+	if err != nil {
+		t.Fatalf("build tls config: %v", err)
+	}
+
+	repository := NewSnapshotDataRepository(staticSnapshot{Teams: map[string][]string{"team-1": {"alice"}, "team-2": {"bob"}}})
+	server := NewServer(ServerConfig{ClientCertificateRoutes: []string{"GET /teams/:id"}}, nil, repository)
+	server.InitRoutes()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	httpServer := &http.Server{Handler: server}
+	go httpServer.Serve(tls.NewListener(listener, tlsConfig))
+	defer httpServer.Close()
+
+	pool, err := loadCertPool(filepath.Join(dir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("load ca: %v", err)
+	}
+	requestTeam := func(certificates ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: certificates}}}
+		response, err := client.Get("https://" + listener.Addr().String() + "/teams/team-1")
+		if err != nil {
+			t.Fatalf("request team: %v", err)
+		}
+		_ = response.Body.Close()
+		return response.StatusCode
+	}
+
+	if code := requestTeam(); code != http.StatusUnauthorized {
+		t.Errorf("expected team lookup without client certificate to be rejected, got %d", code)
+	}
+
+	certificate, err := tls.LoadX509KeyPair(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Fatalf("load client certificate: %v", err)
+	}
+	if code := requestTeam(certificate); code != http.StatusOK {
+		t.Errorf("expected team lookup with client certificate to succeed, got %d", code)
+	}
+}
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+)
+
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStdout = "stdout"
+	TracingExporterFile   = "file"
+)
+
+var tracer = otel.Tracer("github.com/pscheid/teams")
+
+type TracingConfig struct {
+	ServiceName string
+	// Exporter is otlp, stdout or file. Tracing is disabled if it is empty.
+	Exporter string
+	// Endpoint is the host and port of the OTLP/HTTP collector.
+	Endpoint string
+	Insecure bool
+	// File receives the spans of the file exporter.
+	File string
+	// SampleRatio is the share of traces started here that are sampled, none at 0 and all at 1.
+	// Traces of callers keep their sampling decision.
+	SampleRatio float64
+}
+
+// InitTracing installs a global tracer provider and the W3C trace context propagator. The
+// returned function flushes and stops the exporter.
+func InitTracing(config TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if config.Exporter == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, closeExporter, err := buildSpanExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	sampler := sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))
+	switch {
+	case config.SampleRatio <= 0:
+		sampler = sdktrace.ParentBased(sdktrace.NeverSample())
+	case config.SampleRatio >= 1:
+		sampler = sdktrace.ParentBased(sdktrace.AlwaysSample())
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return func(ctx context.Context) error {
+		return errors.Join(provider.Shutdown(ctx), closeExporter())
+	}, nil
+}
+
+func buildSpanExporter(config TracingConfig) (sdktrace.SpanExporter, func() error, error) {
+	noop := func() error { return nil }
+
+	switch config.Exporter {
+	case TracingExporterOTLP:
+		options := []otlptracehttp.Option{}
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+		if config.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err := otlptracehttp.New(context.Background(), options...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tracing: %w", err)
+		}
+		return exporter, noop, nil
+	case TracingExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, nil, fmt.Errorf("tracing: %w", err)
+		}
+		return exporter, noop, nil
+	case TracingExporterFile:
+		file, err := os.OpenFile(config.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tracing: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			_ = file.Close()
+			return nil, nil, fmt.Errorf("tracing: %w", err)
+		}
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("tracing: unknown exporter %q", config.Exporter)
+	}
+}
+
+// startSpan starts a child span of the span of the request. Repository and JwtHelper methods do
+// not take a context, so their spans are started around the calls.
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attributes...))
+	return span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTracingPropagatesFromClient(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := InitTracing(TracingConfig{ServiceName: "test", Exporter: TracingExporterFile, File: path, SampleRatio: 1})
+	if err != nil {
+		t.Fatalf("init tracing: %v", err)
+	}
+
+	repository := NewSnapshotDataRepository(staticSnapshot{
+		Users: map[string]ed25519.PublicKey{"alice": {1}},
+		Teams: map[string][]string{"team-1": {"alice"}},
+	})
+	server := NewServer(ServerConfig{}, nil, repository)
+	server.InitTracing("test")
+	server.InitRoutes()
+
+	httpServer := httptest.NewServer(server)
+	defer httpServer.Close()
+
+	ctx, span := otel.Tracer("test").Start(context.Background(), "caller")
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, httpServer.URL+"/teams/team-1", nil)
+	response, err := (&http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}).Do(request)
+	if err != nil {
+		t.Fatalf("request team: %v", err)
+	}
+	_ = response.Body.Close()
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown tracing: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open traces: %v", err)
+	}
+	defer file.Close()
+
+	traces := make(map[string]string)
+	decoder := json.NewDecoder(file)
+	for {
+		exported := struct {
+			Name        string
+			SpanContext struct{ TraceID string }
+		}{}
+		if err := decoder.Decode(&exported); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("decode traces: %v", err)
+		}
+		traces[exported.Name] = exported.SpanContext.TraceID
+	}
+
+	traceID := span.SpanContext().TraceID().String()
+	for _, name := range []string{"caller", "GET /teams/:id", "DataRepository.GetTeamMembers"} {
+		if traces[name] != traceID {
+			t.Errorf("expected span %s in trace %s, got %v", name, traceID, traces)
+		}
+	}
+}
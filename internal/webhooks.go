@@ -0,0 +1,460 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pscheid/teams/api"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookUserAdded         = "user.added"
+	WebhookUserRemoved       = "user.removed"
+	WebhookUserKeyChanged    = "user.key_changed"
+	WebhookUserRolesChanged  = "user.roles_changed"
+	WebhookTeamAdded         = "team.added"
+	WebhookTeamRemoved       = "team.removed"
+	WebhookTeamMemberAdded   = "team.member_added"
+	WebhookTeamMemberRemoved = "team.member_removed"
+
+	WebhookSignatureHeader = "X-Teams-Signature-256"
+	WebhookEventHeader     = "X-Teams-Event"
+	WebhookDeliveryHeader  = "X-Teams-Delivery"
+)
+
+type ChangeSubscriber interface {
+	Subscribe() (<-chan api.DataChange, func())
+}
+
+type WebhookTarget struct {
+	Name   string
+	URL    string
+	Secret string
+	// Events limits the event types sent to the target, all events are sent if it is empty.
+	Events []string
+}
+
+type WebhookConfig struct {
+	Targets       []WebhookTarget
+	Outbox        string
+	Timeout       time.Duration
+	RetryInterval time.Duration
+	MaxBackoff    time.Duration
+	MaxAttempts   int
+	Client        *http.Client
+}
+
+type WebhookEvent struct {
+	ID       string    `json:"id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Revision string    `json:"revision,omitempty"`
+	User     string    `json:"user,omitempty"`
+	Team     string    `json:"team,omitempty"`
+}
+
+// webhookDelivery is an event on its way to one target. Pending deliveries are kept as files in
+// the outbox directory until the target accepted them, so they survive restarts.
+type webhookDelivery struct {
+	Target      string       `json:"target"`
+	Event       WebhookEvent `json:"event"`
+	Attempts    int          `json:"attempts"`
+	NextAttempt time.Time    `json:"next_attempt"`
+
+	file string
+}
+
+// WebhookDispatcher sends the changes published by a ChangeSubscriber to the configured targets.
+// Each request carries the hex encoded HMAC-SHA256 of the body, keyed with the secret of the
+// target, in the X-Teams-Signature-256 header. Changes are written to the outbox as soon as they
+// are received, and every target is served by its own goroutine, so an unreachable target
+// neither delays the others nor the outbox.
+type WebhookDispatcher struct {
+	config      WebhookConfig
+	targets     map[string]WebhookTarget
+	changes     <-chan api.DataChange
+	unsubscribe func()
+	mutex       sync.Mutex
+	pending     map[string][]*webhookDelivery
+	wake        map[string]chan struct{}
+	ctx         context.Context
+	cancel      context.CancelFunc
+	workers     sync.WaitGroup
+}
+
+func NewWebhookDispatcher(config WebhookConfig, source ChangeSubscriber) (*WebhookDispatcher, error) {
+	if config.Outbox == "" {
+		return nil, errors.New("webhooks: outbox directory is required")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = 5 * time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 10 * time.Minute
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 20
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: config.Timeout}
+	}
+
+	targets := make(map[string]WebhookTarget, len(config.Targets))
+	for _, target := range config.Targets {
+		if target.Name == "" || target.URL == "" {
+			return nil, errors.New("webhooks: every target needs a name and a url")
+		}
+		if target.Secret == "" {
+			// receivers could not tell the deliveries from forged ones
+			return nil, fmt.Errorf("webhooks: target %s needs a secret", target.Name)
+		}
+		if _, ok := targets[target.Name]; ok {
+			return nil, fmt.Errorf("webhooks: target %s is defined more than once", target.Name)
+		}
+		targets[target.Name] = target
+	}
+
+	if err := os.MkdirAll(config.Outbox, 0o700); err != nil {
+		return nil, fmt.Errorf("webhooks: create outbox: %w", err)
+	}
+
+	d := &WebhookDispatcher{
+		config:  config,
+		targets: targets,
+		pending: make(map[string][]*webhookDelivery, len(targets)),
+		wake:    make(map[string]chan struct{}, len(targets)),
+	}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	if err := d.loadOutbox(); err != nil {
+		return nil, err
+	}
+
+	for name := range targets {
+		d.wake[name] = make(chan struct{}, 1)
+	}
+
+	d.changes, d.unsubscribe = source.Subscribe()
+	d.workers.Add(1 + len(targets))
+	go d.receive()
+	for name := range targets {
+		go d.work(name)
+	}
+
+	return d, nil
+}
+
+func (d *WebhookDispatcher) loadOutbox() error {
+	entries, err := os.ReadDir(d.config.Outbox)
+	if err != nil {
+		return fmt.Errorf("webhooks: read outbox: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		file := filepath.Join(d.config.Outbox, entry.Name())
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("webhooks: read outbox: %w", err)
+		}
+
+		delivery := &webhookDelivery{file: file}
+		if err := json.Unmarshal(content, delivery); err != nil {
+			slog.Warn("discarding unreadable webhook delivery", slog.String("file", file), slog.Any("error", err))
+			_ = os.Remove(file)
+			continue
+		}
+		if _, ok := d.targets[delivery.Target]; !ok {
+			slog.Warn("discarding webhook delivery for unknown target", slog.String("delivery", delivery.Event.ID), slog.String("target", delivery.Target))
+			_ = os.Remove(file)
+			continue
+		}
+
+		// the target may have recovered while the server was down
+		delivery.NextAttempt = time.Now()
+		d.pending[delivery.Target] = append(d.pending[delivery.Target], delivery)
+	}
+	return nil
+}
+
+// receive writes every change to the outbox and hands it to the workers of the targets.
+func (d *WebhookDispatcher) receive() {
+	defer d.workers.Done()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case change, ok := <-d.changes:
+			if !ok {
+				return
+			}
+			d.enqueue(change)
+		}
+	}
+}
+
+func (d *WebhookDispatcher) enqueue(change api.DataChange) {
+	now := time.Now()
+	for i, event := range webhookEvents(change) {
+		event.Time = now
+		for _, name := range sortedKeys(d.targets) {
+			target := d.targets[name]
+			if len(target.Events) > 0 && !slices.Contains(target.Events, event.Type) {
+				continue
+			}
+
+			delivery := &webhookDelivery{Target: name, Event: event, NextAttempt: now}
+			delivery.file = filepath.Join(d.config.Outbox, fmt.Sprintf("%020d-%06d-%s-%s.json", now.UnixNano(), i, event.ID, outboxName(name)))
+			d.persist(delivery)
+
+			d.mutex.Lock()
+			d.pending[name] = append(d.pending[name], delivery)
+			d.mutex.Unlock()
+
+			select {
+			case d.wake[name] <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// persist retries writing a new delivery to the outbox until it succeeds, so it is not only
+// kept in memory. Changes received meanwhile wait in the subscription.
+func (d *WebhookDispatcher) persist(delivery *webhookDelivery) {
+	for failures := 0; ; failures++ {
+		err := d.save(delivery)
+		if err == nil {
+			return
+		}
+
+		slog.Error("persisting webhook delivery failed", slog.String("delivery", delivery.Event.ID), slog.String("target", delivery.Target), slog.Any("error", err))
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(backoff(d.config.RetryInterval, d.config.MaxBackoff, failures)):
+		}
+	}
+}
+
+// work sends the deliveries of one target in order of their creation. A failed delivery holds
+// back the later ones until it is sent or given up.
+func (d *WebhookDispatcher) work(name string) {
+	defer d.workers.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-d.wake[name]:
+		case <-timer.C:
+		}
+
+		d.deliverDue(name)
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next, ok := d.nextAttempt(name); ok {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+func (d *WebhookDispatcher) deliverDue(name string) {
+	d.mutex.Lock()
+	deliveries := slices.Clone(d.pending[name])
+	d.mutex.Unlock()
+
+	now := time.Now()
+	for _, delivery := range deliveries {
+		if delivery.NextAttempt.After(now) || d.ctx.Err() != nil {
+			return
+		}
+
+		err := d.deliver(delivery)
+		if err == nil {
+			_ = os.Remove(delivery.file)
+			d.remove(delivery)
+			continue
+		}
+		if d.ctx.Err() != nil {
+			// interrupted by Close, the delivery stays in the outbox
+			return
+		}
+
+		delivery.Attempts++
+		if delivery.Attempts >= d.config.MaxAttempts {
+			slog.Error("giving up on webhook delivery", slog.String("delivery", delivery.Event.ID), slog.String("target", delivery.Target), slog.Int("attempts", delivery.Attempts), slog.Any("error", err))
+			_ = os.Remove(delivery.file)
+			d.remove(delivery)
+			continue
+		}
+
+		delivery.NextAttempt = now.Add(backoff(d.config.RetryInterval, d.config.MaxBackoff, delivery.Attempts-1))
+		slog.Warn("webhook delivery failed", slog.String("delivery", delivery.Event.ID), slog.String("target", delivery.Target), slog.Time("next_attempt", delivery.NextAttempt), slog.Any("error", err))
+		if err := d.save(delivery); err != nil {
+			slog.Error("persisting webhook delivery failed", slog.String("delivery", delivery.Event.ID), slog.Any("error", err))
+		}
+		return
+	}
+}
+
+func (d *WebhookDispatcher) remove(delivery *webhookDelivery) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.pending[delivery.Target] = slices.DeleteFunc(d.pending[delivery.Target], func(pending *webhookDelivery) bool {
+		return pending == delivery
+	})
+}
+
+// nextAttempt is only called by the worker of the target, which is the only one changing the
+// attempts of its deliveries. Only the oldest delivery can be due, as it holds back the others.
+func (d *WebhookDispatcher) nextAttempt(name string) (time.Time, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	pending := d.pending[name]
+	if len(pending) == 0 {
+		return time.Time{}, false
+	}
+	return pending[0].NextAttempt, true
+}
+
+func (d *WebhookDispatcher) deliver(delivery *webhookDelivery) error {
+	target := d.targets[delivery.Target]
+
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(d.ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookEventHeader, delivery.Event.Type)
+	request.Header.Set(WebhookDeliveryHeader, delivery.Event.ID)
+	request.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(body, target.Secret))
+
+	response, err := d.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("unsuccessful status code %d", response.StatusCode)
+	}
+	return nil
+}
+
+// save writes the delivery to a sibling file and renames it, so a crash never leaves a
+// partially written delivery behind.
+func (d *WebhookDispatcher) save(delivery *webhookDelivery) error {
+	content, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+
+	tmp := delivery.file + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, delivery.file)
+}
+
+// Close stops the dispatcher. Deliveries in flight are cancelled and stay in the outbox.
+func (d *WebhookDispatcher) Close() error {
+	d.unsubscribe()
+	d.cancel()
+	d.workers.Wait()
+	return nil
+}
+
+// outboxName keeps target names from reaching outside the outbox or breaking its file names. The
+// deliveries record the actual name of their target.
+func outboxName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func SignWebhook(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookEvents(change api.DataChange) []WebhookEvent {
+	var events []WebhookEvent
+	add := func(eventType string, user string, team string) {
+		events = append(events, WebhookEvent{ID: newEventID(), Type: eventType, Revision: change.Revision, User: user, Team: team})
+	}
+
+	for _, user := range change.UsersAdded {
+		add(WebhookUserAdded, user, "")
+	}
+	for _, user := range change.KeysChanged {
+		add(WebhookUserKeyChanged, user, "")
+	}
+	for _, user := range change.RolesChanged {
+		add(WebhookUserRolesChanged, user, "")
+	}
+	for _, team := range change.TeamsAdded {
+		add(WebhookTeamAdded, "", team)
+	}
+	for _, team := range sortedKeys(change.MembersAdded) {
+		for _, user := range change.MembersAdded[team] {
+			add(WebhookTeamMemberAdded, user, team)
+		}
+	}
+	for _, team := range sortedKeys(change.MembersRemoved) {
+		for _, user := range change.MembersRemoved[team] {
+			add(WebhookTeamMemberRemoved, user, team)
+		}
+	}
+	for _, team := range change.TeamsRemoved {
+		add(WebhookTeamRemoved, "", team)
+	}
+	for _, user := range change.UsersRemoved {
+		add(WebhookUserRemoved, user, "")
+	}
+	return events
+}
+
+func newEventID() string {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
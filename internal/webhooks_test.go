@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type webhookRecorder struct {
+	mutex  sync.Mutex
+	status int
+	events []WebhookEvent
+}
+
+func (r *webhookRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	if req.Header.Get(WebhookSignatureHeader) != "sha256="+SignWebhook(body, "secret") {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.status != 0 {
+		w.WriteHeader(r.status)
+		return
+	}
+
+	event := WebhookEvent{}
+	_ = json.Unmarshal(body, &event)
+	r.events = append(r.events, event)
+}
+
+func (r *webhookRecorder) setStatus(status int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status = status
+}
+
+func (r *webhookRecorder) received() []WebhookEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]WebhookEvent(nil), r.events...)
+}
+
+func joinTeam(tracker *changeTracker) {
+	tracker.track(DataSnapshot{
+		Revision: "1",
+		Users:    map[string]ed25519.PublicKey{"alice": {1}, "bob": {2}},
+		Teams:    map[string][]string{"team-1": {"alice"}},
+	})
+	tracker.track(DataSnapshot{
+		Revision: "2",
+		Users:    map[string]ed25519.PublicKey{"alice": {1}, "bob": {2}},
+		Teams:    map[string][]string{"team-1": {"alice", "bob"}},
+	})
+}
+
+func TestWebhookDispatcherRetries(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusServiceUnavailable}
+	target := httptest.NewServer(recorder)
+	defer target.Close()
+
+	tracker := &changeTracker{}
+	dispatcher, err := NewWebhookDispatcher(WebhookConfig{
+		Targets:       []WebhookTarget{{Name: "test", URL: target.URL, Secret: "secret"}},
+		Outbox:        t.TempDir(),
+		RetryInterval: 20 * time.Millisecond,
+	}, tracker)
+	if err != nil {
+		t.Fatalf("create dispatcher: %v", err)
+	}
+	defer dispatcher.Close()
+
+	joinTeam(tracker)
+	time.Sleep(50 * time.Millisecond)
+	recorder.setStatus(0)
+
+	waitFor(t, func() bool { return len(recorder.received()) == 1 }, "expected event to be delivered after retrying")
+
+	event := recorder.received()[0]
+	if event.Type != WebhookTeamMemberAdded || event.Team != "team-1" || event.User != "bob" || event.Revision != "2" {
+		t.Errorf("unexpected event %+v", event)
+	}
+}
+
+func TestWebhookDispatcherKeepsOutboxAcrossRestarts(t *testing.T) {
+	recorder := &webhookRecorder{status: http.StatusInternalServerError}
+	target := httptest.NewServer(recorder)
+	defer target.Close()
+
+	config := WebhookConfig{
+		Targets:       []WebhookTarget{{Name: "test", URL: target.URL, Secret: "secret", Events: []string{WebhookTeamMemberAdded}}},
+		Outbox:        t.TempDir(),
+		RetryInterval: time.Hour,
+	}
+
+	tracker := &changeTracker{}
+	dispatcher, err := NewWebhookDispatcher(config, tracker)
+	if err != nil {
+		t.Fatalf("create dispatcher: %v", err)
+	}
+	joinTeam(tracker)
+
+	outbox := func() int {
+		entries, _ := os.ReadDir(config.Outbox)
+		return len(entries)
+	}
+	waitFor(t, func() bool { return outbox() == 1 }, "expected failed delivery to be kept in the outbox")
+	_ = dispatcher.Close()
+
+	recorder.setStatus(0)
+	dispatcher, err = NewWebhookDispatcher(config, &changeTracker{})
+	if err != nil {
+		t.Fatalf("create dispatcher: %v", err)
+	}
+	defer dispatcher.Close()
+
+	waitFor(t, func() bool { return len(recorder.received()) == 1 && outbox() == 0 }, "expected pending delivery to be sent after restart")
+}
+
+func TestWebhookDispatcherIsNotBlockedByUnreachableTarget(t *testing.T) {
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	recorder := &webhookRecorder{}
+	target := httptest.NewServer(recorder)
+	defer target.Close()
+
+	tracker := &changeTracker{}
+	config := WebhookConfig{
+		Targets: []WebhookTarget{
+			{Name: "hanging", URL: hanging.URL, Secret: "secret"},
+			{Name: "test", URL: target.URL, Secret: "secret"},
+		},
+		Outbox:  t.TempDir(),
+		Timeout: time.Minute,
+	}
+	dispatcher, err := NewWebhookDispatcher(config, tracker)
+	if err != nil {
+		t.Fatalf("create dispatcher: %v", err)
+	}
+	defer dispatcher.Close()
+
+	// more changes than a subscription used to buffer
+	const changes = 200
+	for i := 0; i <= changes; i++ {
+		tracker.track(DataSnapshot{Revision: strconv.Itoa(i), Users: map[string]ed25519.PublicKey{"alice": {byte(i)}}})
+	}
+
+	waitFor(t, func() bool { return len(recorder.received()) == changes }, "expected every change to reach the reachable target")
+	hangingDeliveries, _ := filepath.Glob(filepath.Join(config.Outbox, "*-hanging.json"))
+	if len(hangingDeliveries) != changes {
+		t.Errorf("expected the deliveries of the unreachable target in the outbox, got %d", len(hangingDeliveries))
+	}
+}
+
+func TestWebhookDispatcherKeepsOrderAfterFailure(t *testing.T) {
+	recorder := &webhookRecorder{}
+	requests := 0
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.mutex.Lock()
+		requests++
+		first := requests == 1
+		recorder.mutex.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		recorder.ServeHTTP(w, r)
+	}))
+	defer target.Close()
+
+	tracker := &changeTracker{}
+	config := WebhookConfig{
+		// the name is no valid file name
+		Targets:       []WebhookTarget{{Name: "ops/chat", URL: target.URL, Secret: "secret"}},
+		Outbox:        t.TempDir(),
+		RetryInterval: 20 * time.Millisecond,
+	}
+	dispatcher, err := NewWebhookDispatcher(config, tracker)
+	if err != nil {
+		t.Fatalf("create dispatcher: %v", err)
+	}
+	defer dispatcher.Close()
+
+	joinTeam(tracker)
+	tracker.track(DataSnapshot{
+		Revision: "3",
+		Users:    map[string]ed25519.PublicKey{"alice": {1}, "bob": {2}},
+		Teams:    map[string][]string{"team-1": {"alice"}},
+	})
+
+	waitFor(t, func() bool { return len(recorder.received()) == 2 }, "expected both events to be delivered")
+	if events := recorder.received(); events[0].Type != WebhookTeamMemberAdded || events[1].Type != WebhookTeamMemberRemoved {
+		t.Errorf("expected the failed delivery to be sent first, got %+v", events)
+	}
+}
+
+func TestWebhookDispatcherRequiresSecrets(t *testing.T) {
+	config := WebhookConfig{
+		Targets: []WebhookTarget{{Name: "test", URL: "http://localhost"}},
+		Outbox:  t.TempDir(),
+	}
+	if _, err := NewWebhookDispatcher(config, &changeTracker{}); err == nil {
+		t.Error("expected a target without secret to be rejected")
+	}
+}